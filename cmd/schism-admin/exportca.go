@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+)
+
+func exportCACommand(args []string) error {
+	flags := flag.NewFlagSet("export-ca", flag.ContinueOnError)
+	nf := &namespaceFlags{}
+	nf.register(flags)
+	outFile := flags.String("out", "", "file to write the OpenSSH private key to, the public key goes to <out>.pub")
+	passphraseFile := flags.String("passphrase-file", "", "file holding a passphrase to encrypt the key with, - reads stdin")
+	comment := flags.String("comment", "", "key comment, defaults to the CA parameter name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	certType, err := nf.certificateType()
+	if err != nil {
+		return err
+	}
+	if *outFile == "" {
+		return fmt.Errorf("-out is required")
+	}
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		return err
+	}
+	config, err := nf.config()
+	if err != nil {
+		return err
+	}
+	caStore, err := cloud.NewCAStore(config, nf.region)
+	if err != nil {
+		return err
+	}
+	keyRing, err := caStore.LoadKeyRing(certType)
+	if err != nil {
+		return err
+	}
+	caPair := keyRing.Current
+	if *comment == "" {
+		*comment = config.CaParamName(certType)
+	}
+	privateKey, err := caPair.ExportOpenSSH(*comment, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := writeNewFile(*outFile, privateKey, 0600); err != nil {
+		return err
+	}
+	publicKey := strings.TrimSuffix(string(caPair.AuthorizedKey), "\n") + " " + *comment + "\n"
+	if err := writeNewFile(*outFile+".pub", []byte(publicKey), 0644); err != nil {
+		return err
+	}
+	if len(passphrase) == 0 {
+		logger.Printf("WARNING: '%s' is not encrypted, store it offline", *outFile)
+	}
+	logger.Printf("Exported %s CA from the %s backend to '%s'", certType, config.CaBackend, *outFile)
+	fmt.Println(caPair.Fingerprint)
+	return nil
+}
+
+// writeNewFile refuses to overwrite, an export should never clobber an older backup
+func writeNewFile(name string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
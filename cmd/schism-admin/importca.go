@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func importCACommand(args []string) error {
+	flags := flag.NewFlagSet("import-ca", flag.ContinueOnError)
+	nf := &namespaceFlags{}
+	nf.register(flags)
+	keyFile := flags.String("key", "", "CA private key in OpenSSH, PKCS#1 or PKCS#8 format")
+	passphraseFile := flags.String("passphrase-file", "", "file holding the key passphrase, - reads stdin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	certType, err := nf.certificateType()
+	if err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		return fmt.Errorf("-key is required")
+	}
+	rawKey, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		return err
+	}
+	caPair, err := crypto.ImportCA(rawKey, passphrase)
+	if err != nil {
+		return err
+	}
+	config, err := nf.config()
+	if err != nil {
+		return err
+	}
+	caStore, err := cloud.NewCAStore(config, nf.region)
+	if err != nil {
+		return err
+	}
+	if err := caStore.CreateCA(certType, caPair); err != nil {
+		return fmt.Errorf("saving to the %s backend (an existing CA is never overwritten): %w", config.CaBackend, err)
+	}
+	logger.Printf("Imported %s CA %s into the %s backend", certType, caPair.Fingerprint, config.CaBackend)
+	return nil
+}
+
+func readPassphrase(passphraseFile string) ([]byte, error) {
+	var raw []byte
+	var err error
+	switch passphraseFile {
+	case "":
+		return nil, nil
+	case "-":
+		raw, err = io.ReadAll(os.Stdin)
+	default:
+		raw, err = os.ReadFile(passphraseFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(raw), "\r\n")), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+)
+
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var (
+	logger = log.New(os.Stderr, "[schism-admin] : ", log.Lmsgprefix)
+
+	commands = map[string]command{
+		"export-ca": {summary: "write a CA private key out in OpenSSH format", run: exportCACommand},
+		"import-ca": {summary: "store an existing CA private key in the CA backend", run: importCACommand},
+	}
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
+	}
+}
+
+// namespaceFlags are shared by every command that works on a CA
+type namespaceFlags struct {
+	region    string
+	namespace string
+	certType  string
+}
+
+func (nf *namespaceFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&nf.region, "region", os.Getenv("AWS_REGION"), "AWS region")
+	flags.StringVar(&nf.namespace, "namespace", cloud.DefaultCANamespace,
+		"CA namespace, defined by "+cloud.CaNamespacesSourceEnvVar)
+	flags.StringVar(&nf.certType, "type", "", "CA certificate type: host or user")
+}
+
+func (nf *namespaceFlags) certificateType() (protocol.CertificateType, error) {
+	certType := protocol.CertificateType(nf.certType)
+	if certType != protocol.HostCertificate && certType != protocol.UserCertificate {
+		return "", fmt.Errorf("-type must be %s or %s", protocol.HostCertificate, protocol.UserCertificate)
+	}
+	return certType, nil
+}
+
+// config is the lambda's environment driven configuration, narrowed to the
+// requested namespace
+func (nf *namespaceFlags) config() (cloud.SchismConfig, error) {
+	config := cloud.SchismConfig{}
+	if err := config.LoadEnv(); err != nil {
+		return config, err
+	}
+	if nf.namespace == cloud.DefaultCANamespace {
+		return config, nil
+	}
+	if config.CaNamespacesSource == "" {
+		return config, fmt.Errorf("%s is not set, only the default namespace exists", cloud.CaNamespacesSourceEnvVar)
+	}
+	doc, err := cloud.NewDocumentStore(nf.region).LoadDocument(config.CaNamespacesSource)
+	if err != nil {
+		return config, err
+	}
+	configs, err := cloud.ParseCANamespaces(doc, config)
+	if err != nil {
+		return config, err
+	}
+	namespaceConfig, ok := configs[nf.namespace]
+	if !ok {
+		return config, fmt.Errorf("unknown CA namespace: %s", nf.namespace)
+	}
+	return namespaceConfig, nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		logger.Fatalf("%s: %s", os.Args[1], err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse battery staple\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		file    string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "no passphrase", file: "", want: nil},
+		{name: "trailing newline is dropped", file: passphraseFile, want: []byte("correct horse battery staple")},
+		{name: "missing file", file: filepath.Join(dir, "nope"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readPassphrase(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readPassphrase() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readPassphrase() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteNewFile(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte("old backup"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{name: "writes a new file", file: filepath.Join(dir, "ca")},
+		{name: "refuses to overwrite", file: existing, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := writeNewFile(tt.file, []byte("key"), 0600)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("writeNewFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			info, err := os.Stat(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("writeNewFile() mode = %v, want 0600", info.Mode().Perm())
+			}
+		})
+	}
+}
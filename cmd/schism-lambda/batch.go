@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+const maxBatchKeys = 16
+
+// processBatchEvent signs every key it can, a bad key only fails its own result
+func processBatchEvent(ns *caNamespace, event lambdaPayload, certType uint32, signer ssh.Signer, out *lambdaResponse) {
+	if event.PublicKey != "" {
+		out.Error = "public_key and keys can't both be set"
+		return
+	}
+	if len(event.Keys) > maxBatchKeys {
+		out.Error = fmt.Sprintf("at most %d keys can be signed in one request", maxBatchKeys)
+		return
+	}
+	seen := map[string]bool{}
+	failed := 0
+	out.Results = make([]batchKeyResult, len(event.Keys))
+	for i, key := range event.Keys {
+		keyEvent := event
+		keyEvent.Keys = nil
+		keyEvent.PublicKey = key.PublicKey
+		keyEvent.Challenge = key.Challenge
+		keyEvent.ChallengeSignature = key.ChallengeSignature
+		if err := signBatchKey(ns, keyEvent, certType, signer, seen, &out.Results[i]); err != nil {
+			errLogger.Printf("Batch key %d was not signed: %s", i, err)
+			out.Results[i].Error = err.Error()
+			failed++
+		}
+	}
+	if failed > 0 {
+		out.Error = fmt.Sprintf("%d of %d keys were not signed", failed, len(event.Keys))
+	}
+}
+
+func signBatchKey(ns *caNamespace, event lambdaPayload, certType uint32, signer ssh.Signer,
+	seen map[string]bool, result *batchKeyResult) error {
+	pubKey, err := crypto.LazyParseAuthorizedKey([]byte(event.PublicKey))
+	if err != nil {
+		return err
+	}
+	result.KeyFingerprint = ssh.FingerprintSHA256(pubKey)
+	if seen[result.KeyFingerprint] {
+		return fmt.Errorf("%s appears more than once", result.KeyFingerprint)
+	}
+	seen[result.KeyFingerprint] = true
+	if err := checkEncryptionKey(event); err != nil {
+		return err
+	}
+	if ns.config.RequireProofOfPossession || event.Challenge != "" {
+		if err := verifyProofOfPossession(ns, event); err != nil {
+			return fmt.Errorf("proof of possession failed: %w", err)
+		}
+	}
+	lookupKey := cloud.BatchLookupKey(event.Identity, event.Principals, event.CertificateType, pubKey)
+	signedCert, ttl, err := eventSignCertificates(ns, event, certType, lookupKey, signer)
+	if err != nil {
+		return err
+	}
+	event.ValidityInterval = ttl
+	if err := eventSaveCertificate(ns, event, lookupKey, signedCert); err != nil {
+		return err
+	}
+	result.LookupKey = lookupKey
+	result.Serial = signedCert.Serial
+	result.ValidAfter = time.Unix(int64(signedCert.ValidAfter), 0).UTC()
+	result.ValidBefore = time.Unix(int64(signedCert.ValidBefore), 0).UTC()
+	return nil
+}
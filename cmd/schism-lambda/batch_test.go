@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestProcessBatchEvent(t *testing.T) {
+	firstKey := testPublicKey(t)
+	secondKey := testPublicKey(t)
+	tests := []struct {
+		name       string
+		keys       []string
+		publicKey  string
+		wantErr    string
+		wantSigned []bool
+	}{
+		{
+			name:       "every key signed",
+			keys:       []string{firstKey, secondKey},
+			wantSigned: []bool{true, true},
+		},
+		{
+			name:       "bad and duplicate keys fail alone",
+			keys:       []string{firstKey, "not-a-key", firstKey, secondKey},
+			wantErr:    "2 of 4 keys were not signed",
+			wantSigned: []bool{true, false, false, true},
+		},
+		{
+			name:       "nothing signed",
+			keys:       []string{"not-a-key"},
+			wantErr:    "1 of 1 keys were not signed",
+			wantSigned: []bool{false},
+		},
+		{
+			name:      "public key and keys",
+			keys:      []string{firstKey},
+			publicKey: secondKey,
+			wantErr:   "public_key and keys can't both be set",
+		},
+		{
+			name:    "too many keys",
+			keys:    make([]string, maxBatchKeys+1),
+			wantErr: "at most 16 keys",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns, _, certStore := testNamespace(t)
+			caPublishes := certStore.caPublishes
+			event := testSignRequest(t, "alice", "alice")
+			event.PublicKey = tt.publicKey
+			for _, key := range tt.keys {
+				event.Keys = append(event.Keys, batchKey{PublicKey: key})
+			}
+			out := &lambdaResponse{}
+			processEvent(ns, event, out)
+			if (tt.wantErr == "" && out.Error != "") || !strings.Contains(out.Error, tt.wantErr) {
+				t.Errorf("processEvent() error = %q, want %q", out.Error, tt.wantErr)
+			}
+			if len(tt.wantSigned) > 0 && len(out.Results) != len(tt.wantSigned) {
+				t.Fatalf("processEvent() got %d results, want %d", len(out.Results), len(tt.wantSigned))
+			}
+			serials := map[uint64]bool{}
+			for i, wantSigned := range tt.wantSigned {
+				result := out.Results[i]
+				if signed := result.Error == ""; signed != wantSigned {
+					t.Errorf("result %d signed = %v (%s), want %v", i, signed, result.Error, wantSigned)
+					continue
+				} else if !signed {
+					continue
+				}
+				_, cert := testStoredCertificate(t, certStore, result.LookupKey)
+				if cert.Serial != result.Serial || ssh.FingerprintSHA256(cert.Key) != result.KeyFingerprint {
+					t.Errorf("result %d does not match the stored certificate", i)
+				}
+				if serials[cert.Serial] {
+					t.Errorf("result %d reused serial %d", i, cert.Serial)
+				}
+				serials[cert.Serial] = true
+			}
+			if certStore.caPublishes != caPublishes {
+				t.Errorf("processEvent() republished a CA while signing")
+			}
+		})
+	}
+}
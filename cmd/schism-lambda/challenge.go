@@ -0,0 +1,28 @@
+package main
+
+import (
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func processChallengeEvent(ns *caNamespace, event lambdaPayload, out *lambdaResponse) {
+	pubKey, err := crypto.LazyParseAuthorizedKey([]byte(event.PublicKey))
+	if err != nil {
+		errLogger.Panicf("%s\nerror parsing the public key to challenge", err)
+	}
+	challenge, err := ns.certStore.IssueChallenge(pubKey, ns.config.ChallengeTTL)
+	if err != nil {
+		errLogger.Panicf("%s\nerror saving the challenge to s3", err)
+	}
+	logger.Printf("Issued challenge for '%s' expiring at %s", challenge.KeyFingerprint, challenge.ExpiresAt)
+	out.Challenge = challenge.Nonce
+	out.ChallengeNamespace = crypto.ProofOfPossessionNamespace
+	out.ChallengeExpiresAt = challenge.ExpiresAt
+}
+
+func verifyProofOfPossession(ns *caNamespace, event lambdaPayload) error {
+	pubKey, err := crypto.LazyParseAuthorizedKey([]byte(event.PublicKey))
+	if err != nil {
+		return err
+	}
+	return ns.certStore.ConsumeChallenge(event.Challenge, pubKey, []byte(event.ChallengeSignature))
+}
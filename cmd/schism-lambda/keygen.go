@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+// generateEventKey swaps a fresh public key into event. The private key only
+// ever leaves here sealed, and is never logged or written to S3.
+func generateEventKey(ns *caNamespace, event *lambdaPayload, certType uint32) (*generatedKey, error) {
+	if event.PublicKey != "" || len(event.Keys) > 0 {
+		return nil, fmt.Errorf("generate_key can't be combined with public_key or keys")
+	}
+	var recipient ssh.PublicKey
+	if event.KeyRecipient != "" {
+		var err error
+		recipient, err = crypto.LazyParseAuthorizedKey([]byte(event.KeyRecipient))
+		if err != nil {
+			return nil, fmt.Errorf("key_recipient: %w", err)
+		} else if recipient.Type() != ssh.KeyAlgoED25519 {
+			return nil, fmt.Errorf("key_recipient: %w", crypto.ErrSealUnsupportedKey)
+		}
+	} else if ns.config.GeneratedKeyKmsKeyId == "" {
+		return nil, fmt.Errorf("generated keys need a key_recipient, %s is not set", cloud.GeneratedKeyKmsKeyIdEnvVar)
+	}
+	authorizedKey, privateKey, err := crypto.GenerateKeyPair(event.GenerateKey, event.Identity)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range privateKey {
+			privateKey[i] = 0
+		}
+	}()
+	// a generated key is held to the same policy as a supplied one, and is
+	// thrown away before it's sealed if it falls short
+	pubKey, err := crypto.LazyParseAuthorizedKey(authorizedKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := ns.config.KeyPolicy().Check(pubKey, certType); err != nil {
+		return nil, err
+	}
+	generated := &generatedKey{PublicKey: string(authorizedKey)}
+	if recipient != nil {
+		generated.RecipientEncryption, generated.EncryptedPrivateKey, err = crypto.Seal(recipient, privateKey)
+		if err != nil {
+			errLogger.Panicf("%s\nerror sealing the generated key", err)
+		}
+	} else {
+		generated.KMSEncryption, generated.EncryptedPrivateKey, err = ns.keySealer.SealWithKMS(ns.config.GeneratedKeyKmsKeyId, privateKey)
+		if err != nil {
+			errLogger.Panicf("%s\nerror sealing the generated key with '%s'", err, ns.config.GeneratedKeyKmsKeyId)
+		}
+	}
+	event.PublicKey = generated.PublicKey
+	logger.Printf("Generated a %s key pair for '%s'", event.GenerateKey, event.Identity)
+	return generated, nil
+}
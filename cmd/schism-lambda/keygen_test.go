@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestGenerateEventKey(t *testing.T) {
+	recipientPub, recipientPriv, _ := ed25519.GenerateKey(rand.Reader)
+	recipient, _ := ssh.NewPublicKey(recipientPub)
+	tests := []struct {
+		name         string
+		algorithm    string
+		kmsKeyId     string
+		keyRecipient string
+		publicKey    string
+		wantErr      string
+	}{
+		{name: "sealed with kms", kmsKeyId: "alias/generated-keys"},
+		{name: "sealed to the recipient", keyRecipient: string(ssh.MarshalAuthorizedKey(recipient))},
+		{name: "nowhere to seal it", wantErr: "generated keys need a key_recipient"},
+		{name: "with a public key", kmsKeyId: "alias/generated-keys", publicKey: "ssh-ed25519 AAAA", wantErr: "can't be combined"},
+		{name: "outside the key policy", algorithm: crypto.ClientKeyAlgoECDSAP384, kmsKeyId: "alias/generated-keys", wantErr: "nistp384 is not allowed"},
+		{name: "unknown algorithm", algorithm: "dsa", kmsKeyId: "alias/generated-keys", wantErr: "unsupported client key algorithm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns, _, _ := testNamespace(t)
+			ns.config.GeneratedKeyKmsKeyId = tt.kmsKeyId
+			ns.config.KeyAllowedECDSACurves = []string{"nistp256"}
+			event := testSignRequest(t, "ci", "deploy")
+			event.PublicKey = tt.publicKey
+			event.GenerateKey = crypto.ClientKeyAlgoED25519
+			if tt.algorithm != "" {
+				event.GenerateKey = tt.algorithm
+			}
+			event.KeyRecipient = tt.keyRecipient
+			generated, err := generateEventKey(ns, &event, ssh.UserCert)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("generateEventKey() error = %v, want %q", err, tt.wantErr)
+				}
+				if sealed := ns.keySealer.(*fakeKeySealer).plaintexts; len(sealed) > 0 {
+					t.Errorf("generateEventKey() sealed a key it rejected")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if event.PublicKey != generated.PublicKey {
+				t.Errorf("generateEventKey() left %q to be signed, want %q", event.PublicKey, generated.PublicKey)
+			}
+			var privateKey []byte
+			if generated.KMSEncryption != nil {
+				if generated.KMSEncryption.KeyId != tt.kmsKeyId {
+					t.Errorf("generateEventKey() sealed with %s, want %s", generated.KMSEncryption.KeyId, tt.kmsKeyId)
+				}
+				sealed := ns.keySealer.(*fakeKeySealer).plaintexts
+				privateKey = sealed[len(sealed)-1]
+			} else if generated.RecipientEncryption != nil {
+				privateKey, err = generated.RecipientEncryption.Open(recipientPriv, generated.EncryptedPrivateKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				t.Fatalf("generateEventKey() returned an unsealed key")
+			}
+			signer, err := ssh.ParsePrivateKey(privateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))); got != strings.TrimSpace(generated.PublicKey) {
+				t.Errorf("sealed private key is for %s, want %s", got, generated.PublicKey)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -9,10 +11,8 @@ import (
 	"golang.org/x/crypto/ssh"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/service/s3/s3iface"
-	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 
-	"code.agarg.me/schism/commonLib"
 	"code.agarg.me/schism/commonLib/protocol"
 
 	"code.agarg.me/schism/lambda-function/internal"
@@ -20,7 +20,7 @@ import (
 	"code.agarg.me/schism/lambda-function/internal/crypto"
 )
 
-type caPairs map[string]*crypto.EncodedCaPair
+type caKeyRings map[string]*crypto.CaKeyRing
 
 var (
 	invokeCount = 0
@@ -30,112 +30,274 @@ var (
 
 	awsRegion    string
 	schismConfig cloud.SchismConfig
-
-	keyPairs caPairs
 )
 
 func init() {
 	logger = internal.SchismLog(os.Stdout)
 	errLogger = internal.SchismLog(os.Stderr)
 
-	schismConfig.LoadEnv()
+	if err := schismConfig.LoadEnv(); err != nil {
+		errLogger.Panicf("%s\nrefusing to start with a malformed configuration", err)
+	}
 
 	awsRegion = os.Getenv("AWS_REGION")
 }
 
-func caKeysInit(ssmSvc ssmiface.SSMAPI, s3Svc s3iface.S3API) (err error) {
-	hostParamName := fmt.Sprintf("%s-%s", schismConfig.CaParamPrefix, protocol.HostCertificate)
-	hostKeyPair, err := cloud.LoadCAFromSSM(ssmSvc, hostParamName)
-	if err != nil {
-		hostKeyPair = crypto.CreateCA()
-		err = cloud.SaveCAToSSM(ssmSvc, hostKeyPair, hostParamName, schismConfig.CaSsmKmsKeyId)
+// caKeysInit reloads the namespace's key rings on every invocation, a rotation
+// done by another container must never leave this one signing with a retired
+// CA. They're only kept after every CA in them has passed its self-test.
+func caKeysInit(ns *caNamespace) error {
+	keyRings := caKeyRings{}
+	var created []protocol.CertificateType
+	for _, certType := range []protocol.CertificateType{protocol.HostCertificate, protocol.UserCertificate} {
+		keyRing, isNew, err := loadOrCreateKeyRing(ns, certType)
 		if err != nil {
-			return
+			return err
+		}
+		keyRings[string(certType)] = keyRing
+		if isNew {
+			created = append(created, certType)
 		}
 	}
-	userParamName := fmt.Sprintf("%s-%s", schismConfig.CaParamPrefix, protocol.UserCertificate)
-	userKeyPair, err := cloud.LoadCAFromSSM(ssmSvc, userParamName)
-	if err != nil {
-		userKeyPair = crypto.CreateCA()
-		err = cloud.SaveCAToSSM(ssmSvc, userKeyPair, userParamName, schismConfig.CaSsmKmsKeyId)
-		if err != nil {
-			return
+	ns.keyPairs = keyRings
+	// a new CA is published with an empty KRL, after that only revocations and
+	// rotations publish either
+	for _, certType := range created {
+		if err := publishCA(ns, certType); err != nil {
+			errLogger.Printf("Error publishing the new (%s) CA: %s", certType, err)
+		}
+		if err := publishKRL(ns, certType); err != nil {
+			errLogger.Printf("Error publishing the empty (%s) KRL: %s", certType, err)
 		}
 	}
-	keyPairs = caPairs{
-		string(protocol.HostCertificate): hostKeyPair,
-		string(protocol.UserCertificate): userKeyPair,
+	if !ns.krlsChecked {
+		// CAs from before KRLs were published have none until their first
+		// revocation, check once per container
+		ns.krlsChecked = true
+		for _, certType := range []protocol.CertificateType{protocol.HostCertificate, protocol.UserCertificate} {
+			if err := publishMissingKRL(ns, certType); err != nil {
+				errLogger.Printf("Error publishing the missing (%s) KRL: %s", certType, err)
+				ns.krlsChecked = false
+			}
+		}
 	}
-	return
+	return nil
 }
 
-func LambdaHandler(requestEvent protocol.RequestSSHCertLambdaPayload) (protocol.RequestSSHCertLambdaResponse, error) {
-	if err := caKeysInit(commonLib.SSMClient(awsRegion), commonLib.S3Client(awsRegion)); err != nil {
+func loadOrCreateKeyRing(ns *caNamespace, certType protocol.CertificateType) (*crypto.CaKeyRing, bool, error) {
+	keyRing, err := ns.caStore.LoadKeyRing(certType)
+	if err == nil {
+		return keyRing, false, nil
+	} else if !errors.Is(err, cloud.ErrNotFound) {
+		// never paper over a damaged CA by generating a new one
+		return nil, false, err
+	}
+	keyPair, err := crypto.CreateCA(ns.config.CaKeyAlgorithm)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := ns.caStore.CreateCA(certType, keyPair); err != nil {
+		return nil, false, err
+	}
+	return &crypto.CaKeyRing{Current: keyPair}, true, nil
+}
+
+func LambdaHandler(ctx context.Context, requestEvent lambdaPayload) (lambdaResponse, error) {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestEvent.RequestID = lc.AwsRequestID
+	}
+	ns, err := loadNamespace(requestEvent.Namespace)
+	if err != nil {
+		errLogger.Panicf("%s\nerror loading CA namespace '%s'", err, requestEvent.Namespace)
+	} else if ns.name != cloud.DefaultCANamespace {
+		logger.Printf("Using CA namespace '%s'\n", ns.name)
+	}
+	return handleEvent(ns, requestEvent)
+}
+
+// handleEvent serves one invocation against ns, starting from its stored CAs
+func handleEvent(ns *caNamespace, requestEvent lambdaPayload) (lambdaResponse, error) {
+	if err := caKeysInit(ns); err != nil {
+		// nothing can be signed, revoked or rotated without both CAs
 		errLogger.Printf("Error initializing the CA keys: %s", err)
+		return lambdaResponse{}, fmt.Errorf("initializing the CA keys for namespace '%s': %w", ns.name, err)
 	}
 
 	invokeCount = invokeCount + 1
-	response := protocol.RequestSSHCertLambdaResponse{}
-	logger.Printf("Processing %s cert generation event\n", requestEvent.CertificateType)
-	logger.Printf("Requested Identity: %s\n", requestEvent.Identity)
-	logger.Printf("Requested Principals: %s\n", requestEvent.Principals)
-	processEvent(requestEvent, &response)
+	response := lambdaResponse{}
+	switch requestEvent.Operation {
+	case "", operationSign:
+		logger.Printf("Processing %s cert generation event\n", requestEvent.CertificateType)
+		logger.Printf("Requested Identity: %s\n", requestEvent.Identity)
+		logger.Printf("Requested Principals: %s\n", requestEvent.Principals)
+		processEvent(ns, requestEvent, &response)
+	case operationRevoke:
+		logger.Printf("Processing cert revocation event\n")
+		processRevokeEvent(ns, requestEvent, &response)
+	case operationRotateCA:
+		logger.Printf("Processing %s CA rotation event: %s\n", requestEvent.CertificateType, requestEvent.RotationStep)
+		processRotateEvent(ns, requestEvent, &response)
+	case operationRenew:
+		logger.Printf("Processing cert renewal event for '%s'\n", requestEvent.LookupKey)
+		processRenewEvent(ns, requestEvent, &response)
+	case operationChallenge:
+		logger.Printf("Processing proof of possession challenge event\n")
+		processChallengeEvent(ns, requestEvent, &response)
+	default:
+		errLogger.Panicf("unknown operation (%s) requested", requestEvent.Operation)
+	}
 	return response, nil
 }
 
-func processEvent(event protocol.RequestSSHCertLambdaPayload, out *protocol.RequestSSHCertLambdaResponse) {
+func processEvent(ns *caNamespace, event lambdaPayload, out *lambdaResponse) {
+	if event.Profile != "" {
+		if err := applyProfile(ns, &event); err != nil {
+			errLogger.Printf("Rejected profile request: %s", err)
+			out.Error = err.Error()
+			return
+		}
+		logger.Printf("Resolved signing profile '%s' to %s principals %s", event.Profile, event.CertificateType, event.Principals)
+	}
 	var certType uint32
 	var signer ssh.Signer
 	var err error
 	if event.CertificateType == protocol.HostCertificate {
 		certType = ssh.HostCert
-		signer, err = keyPairs[string(protocol.HostCertificate)].Signer()
+		signer, err = ns.keyPairs[string(protocol.HostCertificate)].Current.Signer()
 	} else if event.CertificateType == protocol.UserCertificate {
 		certType = ssh.UserCert
-		signer, err = keyPairs[string(protocol.UserCertificate)].Signer()
+		signer, err = ns.keyPairs[string(protocol.UserCertificate)].Current.Signer()
 	} else {
 		errLogger.Panicf("unknown CertificateType (%s) requested", event.CertificateType)
 	}
 	if err != nil {
 		errLogger.Panicf("%s\nerror parsing ssh.Signer from (%s)keyPair", err, event.CertificateType)
 	}
-	out.LookupKey = protocol.GenerateLookupKey(event.Identity, event.Principals, event.CertificateType).String()
-	signedCert := eventSignCertificates(event, certType, err, signer)
-	err = eventUploadResults(event, signedCert)
-	if err != nil {
+	if denied := deniedPrincipals(ns, event); len(denied) > 0 {
+		errLogger.Printf("Identity '%s' is not allowed principals %s", event.Identity, denied)
+		out.Error = "requested principals are not allowed for this identity"
+		out.DeniedPrincipals = denied
+		return
+	}
+	if event.GenerateKey != "" {
+		generated, err := generateEventKey(ns, &event, certType)
+		if err != nil {
+			errLogger.Printf("Rejected key generation: %s", err)
+			out.Error = err.Error()
+			return
+		}
+		out.GeneratedKey = generated
+	}
+	if len(event.Keys) > 0 {
+		processBatchEvent(ns, event, certType, signer, out)
+		return
+	}
+	if err := checkEncryptionKey(event); err != nil {
+		errLogger.Printf("Rejected certificate encryption: %s", err)
+		out.Error = err.Error()
+		return
+	}
+	if event.GenerateKey == "" && (ns.config.RequireProofOfPossession || event.Challenge != "") {
+		if err := verifyProofOfPossession(ns, event); err != nil {
+			errLogger.Printf("Proof of possession failed: %s", err)
+			out.Error = "proof of possession failed: " + err.Error()
+			return
+		}
+	}
+	lookupKey := protocol.GenerateLookupKey(event.Identity, event.Principals, event.CertificateType).String()
+	if event.Operation == operationRenew {
+		// renewals overwrite the record they were loaded from, batch ones included
+		lookupKey = event.LookupKey
+	}
+	signedCert, ttl, err := eventSignCertificates(ns, event, certType, lookupKey, signer)
+	var policyErr *crypto.PolicyError
+	if errors.As(err, &policyErr) {
+		errLogger.Printf("Rejected signing request: %s", err)
+		out.Error = err.Error()
+		return
+	} else if err != nil {
+		errLogger.Panicf("%s\nCert Signing went wrong, see logs for details", err)
+	}
+	out.LookupKey = lookupKey
+	event.ValidityInterval = ttl
+	out.ValidityInterval = ttl
+	out.ValidAfter = time.Unix(int64(signedCert.ValidAfter), 0).UTC()
+	out.ValidBefore = time.Unix(int64(signedCert.ValidBefore), 0).UTC()
+	if err := eventSaveCertificate(ns, event, out.LookupKey, signedCert); err != nil {
 		errLogger.Panicf("%s\nerror saving certificates to s3", err)
 	}
 }
 
-func eventUploadResults(event protocol.RequestSSHCertLambdaPayload, signedCert *ssh.Certificate) error {
-	s3Svc := commonLib.S3Client(awsRegion)
+func eventSaveCertificate(ns *caNamespace, event lambdaPayload, lookupKey string, signedCert *ssh.Certificate) error {
 	marshaledCert := crypto.MarshalSignedCert(signedCert)
-	oppositeCA := event.CertificateType.OppositeCA()
-	s3OppositeCaCert := &protocol.CAPublicKeyS3Object{
-		CertificateType: oppositeCA,
-		AuthorizedKey:   keyPairs[string(oppositeCA)].AuthorizedKey,
-		KeyFingerprint:  keyPairs[string(oppositeCA)].Fingerprint,
-	}
-	if oppositeCA == protocol.HostCertificate {
-		s3OppositeCaCert.HostCertAuthDomain = schismConfig.HostCertsAuthDomain
-	}
-	s3Cert := &protocol.SignedCertificateS3Object{
-		CertificateType:             event.CertificateType,
-		IssuedOn:                    time.Unix(int64(signedCert.ValidAfter), 0),
-		Identity:                    event.Identity,
-		Principals:                  event.Principals,
-		ValidityInterval:            event.ValidityInterval,
-		RawSignedCertificate:        marshaledCert,
-		OppositePublicCA:            s3OppositeCaCert.ObjectKey(schismConfig.CertsS3Prefix),
-		SignedCertificateEncryption: nil,
-	}
-	objKey, err := cloud.SaveS3Object(s3Svc, schismConfig, s3Cert)
+	s3OppositeCaCert := caPublicKeyObject(ns, event.CertificateType.OppositeCA())
+	s3Cert := &cloud.SignedCertificateRecord{
+		SignedCertificateS3Object: protocol.SignedCertificateS3Object{
+			CertificateType:      event.CertificateType,
+			IssuedOn:             time.Unix(int64(signedCert.ValidAfter), 0),
+			Identity:             event.Identity,
+			Principals:           event.Principals,
+			ValidityInterval:     event.ValidityInterval,
+			RawSignedCertificate: marshaledCert,
+			OppositePublicCA:     s3OppositeCaCert.ObjectKey(ns.config.CertsS3Prefix),
+		},
+		LookupKey:   lookupKey,
+		Serial:      signedCert.Serial,
+		Profile:     event.Profile,
+		ValidBefore: time.Unix(int64(signedCert.ValidBefore), 0).UTC(),
+	}
+	// the certificate this one replaces may still be valid, keep its serials so
+	// revoking the lookup key revokes them too
+	previous, err := ns.certStore.LoadCertificate(lookupKey)
+	if err != nil && !errors.Is(err, cloud.ErrNotFound) {
+		return err
+	}
+	s3Cert.Supersede(previous, time.Now())
+	if event.EncryptCertificate {
+		if err := s3Cert.Seal(signedCert.Key); err != nil {
+			return err
+		}
+	}
+	objKey, err := ns.certStore.SaveCertificate(s3Cert)
 	if err != nil {
 		return err
 	} else {
 		logger.Printf("Saved Certificate to '%s'", objKey)
 	}
-	objKey, err = cloud.SaveS3Object(s3Svc, schismConfig, s3OppositeCaCert)
+	return nil
+}
+
+// checkEncryptionKey catches keys that can't be sealed to before anything is signed
+func checkEncryptionKey(event lambdaPayload) error {
+	if !event.EncryptCertificate {
+		return nil
+	}
+	pubKey, err := crypto.LazyParseAuthorizedKey([]byte(event.PublicKey))
+	if err != nil {
+		return err
+	}
+	if pubKey.Type() != ssh.KeyAlgoED25519 {
+		return fmt.Errorf("encrypted certificates need an %s key, not %s", ssh.KeyAlgoED25519, pubKey.Type())
+	}
+	return nil
+}
+
+// caPublicKeyObject lists every key certType currently trusts, so hosts keep
+// accepting both CAs while a rotation is in progress.
+func caPublicKeyObject(ns *caNamespace, certType protocol.CertificateType) *protocol.CAPublicKeyS3Object {
+	s3CaCert := &protocol.CAPublicKeyS3Object{
+		CertificateType: certType,
+		AuthorizedKey:   ns.keyPairs[string(certType)].AuthorizedKeys(),
+		KeyFingerprint:  ns.keyPairs[string(certType)].Current.Fingerprint,
+	}
+	if certType == protocol.HostCertificate {
+		s3CaCert.HostCertAuthDomain = ns.config.HostCertsAuthDomain
+	}
+	return s3CaCert
+}
+
+func publishCA(ns *caNamespace, certType protocol.CertificateType) error {
+	objKey, err := ns.certStore.PublishCA(caPublicKeyObject(ns, certType))
 	if err != nil {
 		return err
 	} else {
@@ -144,19 +306,89 @@ func eventUploadResults(event protocol.RequestSSHCertLambdaPayload, signedCert *
 	return nil
 }
 
-func eventSignCertificates(event protocol.RequestSSHCertLambdaPayload, certType uint32, err error, signer ssh.Signer) *ssh.Certificate {
+// publishKRL rebuilds the KRL for the current CA keys. Revocations publish
+// their own, this is only needed when the CA keys change.
+func publishKRL(ns *caNamespace, certType protocol.CertificateType) error {
+	revocations, err := ns.certStore.LoadRevocationList(certType)
+	if err != nil {
+		return err
+	}
+	caKeys, err := caPublicKeys(ns, certType)
+	if err != nil {
+		return err
+	}
+	objKey, err := ns.certStore.PublishKRL(revocations, caKeys)
+	if errors.Is(err, cloud.ErrKRLSuperseded) {
+		logger.Printf("Not publishing KRL version %d: %s", revocations.Version, err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	logger.Printf("Published KRL version %d to '%s'", revocations.Version, objKey)
+	return nil
+}
+
+func publishMissingKRL(ns *caNamespace, certType protocol.CertificateType) error {
+	revocations, err := ns.certStore.LoadRevocationList(certType)
+	if err != nil {
+		return err
+	}
+	caKeys, err := caPublicKeys(ns, certType)
+	if err != nil {
+		return err
+	}
+	objKey, err := ns.certStore.PublishMissingKRL(revocations, caKeys)
+	if errors.Is(err, cloud.ErrKRLSuperseded) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	logger.Printf("Published missing KRL version %d to '%s'", revocations.Version, objKey)
+	return nil
+}
+
+func caPublicKeys(ns *caNamespace, certType protocol.CertificateType) ([]ssh.PublicKey, error) {
+	return ns.keyPairs[string(certType)].PublicKeys()
+}
+
+func eventSignCertificates(ns *caNamespace, event lambdaPayload, certType uint32, lookupKey string, signer ssh.Signer) (*ssh.Certificate, time.Duration, error) {
 	myReq := &crypto.SigningReq{
 		PublicKey:  []byte(event.PublicKey),
 		CertType:   certType,
 		Identity:   event.Identity,
 		Principals: event.Principals,
 		TTL:        event.ValidityInterval,
+
+		CriticalOptions: event.CriticalOptions,
+		Extensions:      event.Extensions,
+
+		StartsAt:      event.StartsAt,
+		Backdate:      ns.config.CertBackdate,
+		MaxStartDelay: ns.config.CertMaxStartDelay,
+
+		LookupKey: lookupKey,
+		Serials:   ns.certStore.Serials(),
+
+		KeyPolicy: ns.config.KeyPolicy(),
+		TTLPolicy: ns.config.TTLPolicy(),
+
+		HostPrincipalPolicy: ns.config.HostPrincipalPolicy(),
+
+		KeyIDTemplate: ns.config.KeyIDTemplate,
+		CallerARN:     event.CallerARN,
+		Profile:       event.Profile,
+		RequestID:     event.RequestID,
 	}
 	signedCert, err := crypto.Sign(myReq, signer)
 	if err != nil {
-		errLogger.Panicf("%s\nCert Signing went wrong, see logs for details", err)
+		return nil, 0, err
+	}
+	ttl, _ := myReq.EffectiveTTL()
+	if ttl != event.ValidityInterval {
+		logger.Printf("Requested validity %s adjusted to %s", event.ValidityInterval, ttl)
 	}
-	return signedCert
+	logger.Printf("Issued certificate serial %d for '%s' with key id '%s'", signedCert.Serial, lookupKey, signedCert.KeyId)
+	return signedCert, ttl, nil
 }
 
 func main() {
@@ -1 +1,421 @@
 package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+	"code.agarg.me/schism/lambda-function/internal/policy"
+)
+
+// fakeCAStore hands out copies of its key rings, like a real store would
+type fakeCAStore struct {
+	keyRings map[protocol.CertificateType]*crypto.CaKeyRing
+	loads    int
+	loadErr  error
+}
+
+func (f *fakeCAStore) LoadKeyRing(certType protocol.CertificateType) (*crypto.CaKeyRing, error) {
+	f.loads++
+	if f.loadErr != nil {
+		return nil, f.loadErr
+	}
+	keyRing, ok := f.keyRings[certType]
+	if !ok {
+		return nil, fmt.Errorf("no %s CA: %w", certType, cloud.ErrNotFound)
+	}
+	loaded := *keyRing
+	return &loaded, nil
+}
+
+func (f *fakeCAStore) CreateCA(certType protocol.CertificateType, caPair *crypto.EncodedCaPair) error {
+	if _, exists := f.keyRings[certType]; exists {
+		return fmt.Errorf("%s CA already exists", certType)
+	}
+	f.keyRings[certType] = &crypto.CaKeyRing{Current: caPair}
+	return nil
+}
+
+func (f *fakeCAStore) SaveKeyRing(certType protocol.CertificateType, keyRing *crypto.CaKeyRing) error {
+	saved := *keyRing
+	f.keyRings[certType] = &saved
+	return nil
+}
+
+// fakeCertStore round trips certificates through JSON so sealed records lose
+// exactly what they would in S3
+type fakeCertStore struct {
+	certs       map[string][]byte
+	caObjects   map[protocol.CertificateType]*protocol.CAPublicKeyS3Object
+	caPublishes int
+	revocations map[protocol.CertificateType]*cloud.RevocationListS3Object
+	krlVersions map[protocol.CertificateType]uint64
+	serials     *crypto.MemorySerialAllocator
+}
+
+func newFakeCertStore() *fakeCertStore {
+	return &fakeCertStore{
+		certs:       map[string][]byte{},
+		caObjects:   map[protocol.CertificateType]*protocol.CAPublicKeyS3Object{},
+		revocations: map[protocol.CertificateType]*cloud.RevocationListS3Object{},
+		krlVersions: map[protocol.CertificateType]uint64{},
+		serials:     crypto.NewMemorySerialAllocator(1),
+	}
+}
+
+func (f *fakeCertStore) SaveCertificate(record *cloud.SignedCertificateRecord) (string, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	f.certs[record.LookupKey] = raw
+	return record.ObjectKey(""), nil
+}
+
+func (f *fakeCertStore) LoadCertificate(lookupKey string) (*cloud.SignedCertificateRecord, error) {
+	raw, ok := f.certs[lookupKey]
+	if !ok {
+		return nil, fmt.Errorf("no certificate for %s: %w", lookupKey, cloud.ErrNotFound)
+	}
+	record := &cloud.SignedCertificateRecord{}
+	return record, json.Unmarshal(raw, record)
+}
+
+func (f *fakeCertStore) PublishCA(caObject *protocol.CAPublicKeyS3Object) (string, error) {
+	f.caObjects[caObject.CertificateType] = caObject
+	f.caPublishes++
+	return caObject.ObjectKey(""), nil
+}
+
+func (f *fakeCertStore) LoadRevocationList(certType protocol.CertificateType) (*cloud.RevocationListS3Object, error) {
+	list := &cloud.RevocationListS3Object{CertificateType: certType}
+	if stored, ok := f.revocations[certType]; ok {
+		*list = *stored
+		list.Revocations = append([]cloud.Revocation{}, stored.Revocations...)
+	}
+	return list, nil
+}
+
+func (f *fakeCertStore) RecordRevocation(certType protocol.CertificateType, caKeys []ssh.PublicKey, revocation cloud.Revocation) (*cloud.RevocationListS3Object, error) {
+	list, _ := f.LoadRevocationList(certType)
+	list.Version++
+	list.Revocations = append(list.Revocations, revocation)
+	f.revocations[certType] = list
+	if _, err := f.PublishKRL(list, caKeys); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (f *fakeCertStore) PublishKRL(list *cloud.RevocationListS3Object, _ []ssh.PublicKey) (string, error) {
+	if published, ok := f.krlVersions[list.CertificateType]; ok && published > list.Version {
+		return "", cloud.ErrKRLSuperseded
+	}
+	f.krlVersions[list.CertificateType] = list.Version
+	return cloud.KRLObjectKey("", list.CertificateType), nil
+}
+
+func (f *fakeCertStore) PublishMissingKRL(list *cloud.RevocationListS3Object, _ []ssh.PublicKey) (string, error) {
+	if _, ok := f.krlVersions[list.CertificateType]; ok {
+		return "", cloud.ErrKRLSuperseded
+	}
+	f.krlVersions[list.CertificateType] = list.Version
+	return cloud.KRLObjectKey("", list.CertificateType), nil
+}
+
+func (f *fakeCertStore) Serials() crypto.SerialAllocator {
+	return f.serials
+}
+
+func (f *fakeCertStore) LoadSerialRecord(serial uint64) (*cloud.SerialRecordS3Object, error) {
+	lookupKey, ok := f.serials.LookupKey(serial)
+	if !ok {
+		return nil, fmt.Errorf("serial %d: %w", serial, cloud.ErrNotFound)
+	}
+	return &cloud.SerialRecordS3Object{Serial: serial, LookupKey: lookupKey}, nil
+}
+
+func (f *fakeCertStore) IssueChallenge(ssh.PublicKey, time.Duration) (*cloud.ChallengeS3Object, error) {
+	return nil, errors.New("challenges are not faked")
+}
+
+func (f *fakeCertStore) ConsumeChallenge(string, ssh.PublicKey, []byte) error {
+	return errors.New("challenges are not faked")
+}
+
+// fakeDocumentStore serves policy documents by source
+type fakeDocumentStore map[string][]byte
+
+func (f fakeDocumentStore) LoadDocument(source string) ([]byte, error) {
+	doc, ok := f[source]
+	if !ok {
+		return nil, fmt.Errorf("no document at %s", source)
+	}
+	return doc, nil
+}
+
+// fakeKeySealer keeps what it was asked to seal instead of calling KMS
+type fakeKeySealer struct {
+	plaintexts [][]byte
+}
+
+func (f *fakeKeySealer) SealWithKMS(keyId string, plaintext []byte) (*cloud.KMSSealedBox, []byte, error) {
+	f.plaintexts = append(f.plaintexts, append([]byte{}, plaintext...))
+	return &cloud.KMSSealedBox{Scheme: cloud.SealSchemeKMSDataKey, KeyId: keyId}, []byte("sealed"), nil
+}
+
+func testNamespace(t *testing.T) (*caNamespace, *fakeCAStore, *fakeCertStore) {
+	t.Helper()
+	config := cloud.SchismConfig{}
+	if err := config.LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	caStore := &fakeCAStore{keyRings: map[protocol.CertificateType]*crypto.CaKeyRing{}}
+	certStore := newFakeCertStore()
+	ns := &caNamespace{
+		name:      "test",
+		config:    config,
+		caStore:   caStore,
+		certStore: certStore,
+		documents: fakeDocumentStore{},
+		keySealer: &fakeKeySealer{},
+	}
+	if err := caKeysInit(ns); err != nil {
+		t.Fatal(err)
+	}
+	return ns, caStore, certStore
+}
+
+func testPublicKey(t *testing.T) string {
+	t.Helper()
+	keyPair, err := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(keyPair.AuthorizedKey)
+}
+
+func testSignRequest(t *testing.T, identity string, principals ...string) lambdaPayload {
+	t.Helper()
+	event := lambdaPayload{}
+	event.CertificateType = protocol.UserCertificate
+	event.Identity = identity
+	event.Principals = principals
+	event.PublicKey = testPublicKey(t)
+	event.ValidityInterval = time.Hour
+	return event
+}
+
+func testStoredCertificate(t *testing.T, certStore *fakeCertStore, lookupKey string) (*cloud.SignedCertificateRecord, *ssh.Certificate) {
+	t.Helper()
+	record, err := certStore.LoadCertificate(lookupKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := record.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record, cert
+}
+
+func wantPanic(t *testing.T, name string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s did not panic", name)
+		}
+	}()
+	f()
+}
+
+func TestCaKeysInit(t *testing.T) {
+	ns, caStore, certStore := testNamespace(t)
+	for _, certType := range []protocol.CertificateType{protocol.HostCertificate, protocol.UserCertificate} {
+		if caStore.keyRings[certType] == nil {
+			t.Errorf("caKeysInit() did not create the %s CA", certType)
+		}
+		if certStore.caObjects[certType] == nil {
+			t.Errorf("caKeysInit() did not publish the new %s CA", certType)
+		}
+		if _, published := certStore.krlVersions[certType]; !published {
+			t.Errorf("caKeysInit() did not publish an empty %s KRL for the new CA", certType)
+		}
+	}
+
+	// another container rotates the user CA, the next invocation must see it
+	rotated := *caStore.keyRings[protocol.UserCertificate]
+	if err := rotated.Rotate(crypto.RotationStepGenerate, ns.config.CaKeyAlgorithm); err != nil {
+		t.Fatal(err)
+	}
+	caStore.keyRings[protocol.UserCertificate] = &rotated
+	loads, caPublishes := caStore.loads, certStore.caPublishes
+	if err := caKeysInit(ns); err != nil {
+		t.Fatal(err)
+	}
+	if caStore.loads != loads+2 {
+		t.Errorf("caKeysInit() made %d key ring loads, want one per CA", caStore.loads-loads)
+	}
+	if state := ns.keyPairs[string(protocol.UserCertificate)].State(); state != crypto.RotationPending {
+		t.Errorf("caKeysInit() kept a %s user CA, want the stored %s one", state, crypto.RotationPending)
+	}
+	if certStore.caPublishes != caPublishes {
+		t.Errorf("caKeysInit() republished existing CAs")
+	}
+
+	// CAs from before KRLs were published get one on the next cold start
+	existingCerts := newFakeCertStore()
+	existingCerts.krlVersions[protocol.HostCertificate] = 5
+	existing := &caNamespace{name: "existing", config: ns.config, caStore: caStore, certStore: existingCerts}
+	if err := caKeysInit(existing); err != nil {
+		t.Fatal(err)
+	}
+	if _, published := existingCerts.krlVersions[protocol.UserCertificate]; !published {
+		t.Errorf("caKeysInit() did not publish the missing user KRL")
+	}
+	if version := existingCerts.krlVersions[protocol.HostCertificate]; version != 5 {
+		t.Errorf("caKeysInit() replaced the published host KRL with version %d", version)
+	}
+
+	damaged := &caNamespace{name: "damaged", caStore: &fakeCAStore{loadErr: crypto.ErrCaPairSelfTestFailed}}
+	if err := caKeysInit(damaged); !errors.Is(err, crypto.ErrCaPairSelfTestFailed) {
+		t.Errorf("caKeysInit() error = %v, want %v", err, crypto.ErrCaPairSelfTestFailed)
+	}
+	if damaged.keyPairs != nil {
+		t.Errorf("caKeysInit() cached key rings that failed to load")
+	}
+}
+
+func TestLambdaHandler_DamagedCA(t *testing.T) {
+	ns := &caNamespace{name: "damaged", caStore: &fakeCAStore{loadErr: crypto.ErrCaPairSelfTestFailed}}
+	namespaces[ns.name] = ns
+	t.Cleanup(func() { delete(namespaces, ns.name) })
+
+	event := testSignRequest(t, "alice", "alice")
+	event.Namespace = ns.name
+	_, err := LambdaHandler(context.Background(), event)
+	if !errors.Is(err, crypto.ErrCaPairSelfTestFailed) {
+		t.Errorf("LambdaHandler() error = %v, want %v", err, crypto.ErrCaPairSelfTestFailed)
+	}
+}
+
+func TestProcessEvent(t *testing.T) {
+	ns, _, certStore := testNamespace(t)
+	ruleSet, err := policy.ParseRuleSet([]byte(`{"rules": [{"identity": "alice", "principals": ["*"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.config.PrincipalRulesSource = "test"
+	ns.principalRules = &policy.Cached[*policy.RuleSet]{Load: func() (*policy.RuleSet, error) { return ruleSet, nil }}
+
+	tests := []struct {
+		name       string
+		event      lambdaPayload
+		wantErr    string
+		wantDenied []string
+	}{
+		{name: "allowed principals", event: testSignRequest(t, "alice", "alice", "deploy")},
+		{
+			name:       "unknown identity",
+			event:      testSignRequest(t, "mallory", "alice"),
+			wantErr:    "requested principals are not allowed",
+			wantDenied: []string{"alice"},
+		},
+		{
+			name:       "no principals",
+			event:      testSignRequest(t, "alice"),
+			wantErr:    "requested principals are not allowed",
+			wantDenied: []string{policy.AnyPrincipal},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &lambdaResponse{}
+			processEvent(ns, tt.event, out)
+			if !strings.Contains(out.Error, tt.wantErr) || (tt.wantErr == "" && out.Error != "") {
+				t.Fatalf("processEvent() error = %q, want %q", out.Error, tt.wantErr)
+			}
+			if fmt.Sprint(out.DeniedPrincipals) != fmt.Sprint(tt.wantDenied) {
+				t.Errorf("processEvent() denied = %v, want %v", out.DeniedPrincipals, tt.wantDenied)
+			}
+			_, stored := certStore.certs[out.LookupKey]
+			if stored != (tt.wantErr == "") {
+				t.Errorf("processEvent() stored a certificate = %v, want %v", stored, tt.wantErr == "")
+			}
+		})
+	}
+	if certStore.caPublishes != 2 {
+		t.Errorf("processEvent() republished a CA while signing, %d publishes", certStore.caPublishes)
+	}
+	if version := certStore.krlVersions[protocol.UserCertificate]; version != 0 {
+		t.Errorf("processEvent() republished the KRL at version %d", version)
+	}
+}
+
+func TestProcessEvent_PolicyRejections(t *testing.T) {
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakPublicKey, err := ssh.NewPublicKey(&weakKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		config  func(*cloud.SchismConfig)
+		event   func(*lambdaPayload)
+		wantErr string
+	}{
+		{
+			name:    "weak subject key",
+			event:   func(event *lambdaPayload) { event.PublicKey = string(ssh.MarshalAuthorizedKey(weakPublicKey)) },
+			wantErr: "subject rsa key is 1024 bits",
+		},
+		{
+			name:    "validity over the maximum",
+			config:  func(config *cloud.SchismConfig) { config.TTLMode = crypto.TTLModeReject },
+			event:   func(event *lambdaPayload) { event.ValidityInterval = 48 * time.Hour },
+			wantErr: "exceeds",
+		},
+		{
+			name:   "host outside the allowed domains",
+			config: func(config *cloud.SchismConfig) { config.HostAllowedDomains = []string{"example.com"} },
+			event: func(event *lambdaPayload) {
+				event.CertificateType = protocol.HostCertificate
+				event.Principals = []string{"web.example.net"}
+			},
+			wantErr: "web.example.net",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns, _, certStore := testNamespace(t)
+			if tt.config != nil {
+				tt.config(&ns.config)
+			}
+			event := testSignRequest(t, "alice", "alice")
+			tt.event(&event)
+			out := &lambdaResponse{}
+			processEvent(ns, event, out)
+			if out.Error == "" || !strings.Contains(out.Error, tt.wantErr) {
+				t.Errorf("processEvent() error = %q, want %q", out.Error, tt.wantErr)
+			}
+			if out.LookupKey != "" || len(certStore.certs) > 0 {
+				t.Errorf("processEvent() stored a certificate it rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+	"code.agarg.me/schism/lambda-function/internal/policy"
+)
+
+// caNamespace is one independent set of host and user CAs with its own
+// storage and policy, loaded the first time a request names it
+type caNamespace struct {
+	name   string
+	config cloud.SchismConfig
+
+	caStore   cloud.CAStore
+	certStore cloud.CertStore
+	documents cloud.DocumentStore
+	keySealer cloud.KeySealer
+
+	keyPairs        caKeyRings
+	krlsChecked     bool
+	principalRules  *policy.Cached[*policy.RuleSet]
+	signingProfiles map[string]*policy.Profile
+}
+
+var (
+	namespaceConfigs map[string]cloud.SchismConfig
+	namespaces       = map[string]*caNamespace{}
+)
+
+func namespacesInit(documents cloud.DocumentStore) error {
+	if namespaceConfigs != nil {
+		return nil
+	}
+	if schismConfig.CaNamespacesSource == "" {
+		namespaceConfigs = map[string]cloud.SchismConfig{cloud.DefaultCANamespace: schismConfig}
+		return nil
+	}
+	doc, err := documents.LoadDocument(schismConfig.CaNamespacesSource)
+	if err != nil {
+		return err
+	}
+	configs, err := cloud.ParseCANamespaces(doc, schismConfig)
+	if err != nil {
+		return err
+	}
+	namespaceConfigs = configs
+	logger.Printf("Loaded %d CA namespaces from '%s'", len(configs)-1, schismConfig.CaNamespacesSource)
+	return nil
+}
+
+func loadNamespace(name string) (*caNamespace, error) {
+	if ns, loaded := namespaces[name]; loaded {
+		return ns, nil
+	}
+	documents := cloud.NewDocumentStore(awsRegion)
+	if err := namespacesInit(documents); err != nil {
+		return nil, err
+	}
+	config, known := namespaceConfigs[name]
+	if !known {
+		return nil, fmt.Errorf("unknown CA namespace: %s", name)
+	}
+	caStore, err := cloud.NewCAStore(config, awsRegion)
+	if err != nil {
+		return nil, err
+	}
+	certStore, err := cloud.NewCertStore(config, awsRegion)
+	if err != nil {
+		return nil, err
+	}
+	ns := &caNamespace{
+		name:      name,
+		config:    config,
+		caStore:   caStore,
+		certStore: certStore,
+		documents: documents,
+		keySealer: cloud.NewKeySealer(awsRegion),
+	}
+	if err := policyInit(ns); err != nil {
+		return nil, err
+	}
+	namespaces[name] = ns
+	return ns, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+)
+
+func TestLoadNamespace(t *testing.T) {
+	defaultConfig := cloud.SchismConfig{CaBackend: cloud.CaBackendSSM, CertBackend: cloud.CertBackendS3, CertsS3Prefix: "default/"}
+	stagingConfig := defaultConfig
+	stagingConfig.CertsS3Prefix = "staging/"
+	brokenConfig := defaultConfig
+	brokenConfig.CaBackend = "floppy"
+
+	savedConfigs, savedNamespaces := namespaceConfigs, namespaces
+	t.Cleanup(func() { namespaceConfigs, namespaces = savedConfigs, savedNamespaces })
+	namespaceConfigs = map[string]cloud.SchismConfig{
+		cloud.DefaultCANamespace: defaultConfig,
+		"staging":                stagingConfig,
+		"broken":                 brokenConfig,
+	}
+	namespaces = map[string]*caNamespace{}
+
+	tests := []struct {
+		name       string
+		namespace  string
+		wantPrefix string
+		wantErr    bool
+	}{
+		{name: "default namespace", namespace: cloud.DefaultCANamespace, wantPrefix: "default/"},
+		{name: "named namespace", namespace: "staging", wantPrefix: "staging/"},
+		{name: "unknown namespace", namespace: "production", wantErr: true},
+		{name: "unknown CA backend", namespace: "broken", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadNamespace(tt.namespace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadNamespace() error = %v, wantErr %v", err, tt.wantErr)
+			} else if err != nil {
+				if _, cached := namespaces[tt.namespace]; cached {
+					t.Errorf("loadNamespace() cached a namespace that failed to load")
+				}
+				return
+			}
+			if got.name != tt.namespace || got.config.CertsS3Prefix != tt.wantPrefix {
+				t.Errorf("loadNamespace() got %s with prefix %s, want %s with %s",
+					got.name, got.config.CertsS3Prefix, tt.namespace, tt.wantPrefix)
+			}
+			if again, _ := loadNamespace(tt.namespace); again != got {
+				t.Errorf("loadNamespace() did not reuse the loaded namespace")
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"time"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+const (
+	operationSign      = "sign"
+	operationRevoke    = "revoke"
+	operationRotateCA  = "rotate-ca"
+	operationChallenge = "challenge"
+	operationRenew     = "renew"
+)
+
+type lambdaPayload struct {
+	protocol.RequestSSHCertLambdaPayload
+	Operation string `json:"operation,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+
+	// CallerARN is whatever the invoking front end vouches for, it only ends up in the KeyId
+	CallerARN string `json:"caller_arn,omitempty"`
+	RequestID string `json:"-"`
+
+	CriticalOptions map[string]string `json:"critical_options,omitempty"`
+	Extensions      map[string]string `json:"extensions,omitempty"`
+	StartsAt        time.Time         `json:"starts_at,omitempty"`
+
+	// EncryptCertificate seals the stored certificate to the (ed25519) key being
+	// signed and leaves the identity and principals out of the stored object, a
+	// sealed certificate can't be renewed
+	EncryptCertificate bool `json:"encrypt_certificate,omitempty"`
+
+	Challenge          string `json:"challenge,omitempty"`
+	ChallengeSignature string `json:"challenge_signature,omitempty"`
+
+	// GenerateKey asks for a fresh key pair of this crypto.ClientKeyAlgo* algorithm instead
+	// of signing PublicKey, the private key comes back sealed to KeyRecipient or a KMS data key
+	GenerateKey  string `json:"generate_key,omitempty"`
+	KeyRecipient string `json:"key_recipient,omitempty"`
+
+	// Keys signs several public keys for the same identity and principals, PublicKey must be empty
+	Keys []batchKey `json:"keys,omitempty"`
+
+	// revoking a LookupKey without a Serial also revokes the earlier certificates
+	// issued to it that are still valid
+	LookupKey string `json:"lookup_key,omitempty"`
+	Serial    uint64 `json:"serial,omitempty"`
+	KeyID     string `json:"key_id,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+
+	RotationStep string `json:"rotation_step,omitempty"`
+}
+
+type lambdaResponse struct {
+	protocol.RequestSSHCertLambdaResponse
+	Error            string   `json:"error,omitempty"`
+	DeniedPrincipals []string `json:"denied_principals,omitempty"`
+
+	ValidityInterval time.Duration `json:"validity_interval,omitempty"`
+	ValidAfter       time.Time     `json:"valid_after,omitempty"`
+	ValidBefore      time.Time     `json:"valid_before,omitempty"`
+
+	Challenge          string    `json:"challenge,omitempty"`
+	ChallengeNamespace string    `json:"challenge_namespace,omitempty"`
+	ChallengeExpiresAt time.Time `json:"challenge_expires_at,omitempty"`
+
+	GeneratedKey *generatedKey `json:"generated_key,omitempty"`
+
+	// Results line up with the request's Keys
+	Results []batchKeyResult `json:"results,omitempty"`
+
+	Revocation *cloud.Revocation `json:"revocation,omitempty"`
+	KRLVersion uint64            `json:"krl_version,omitempty"`
+
+	CAState             string   `json:"ca_state,omitempty"`
+	TrustedFingerprints []string `json:"trusted_fingerprints,omitempty"`
+}
+
+type batchKey struct {
+	PublicKey          string `json:"public_key"`
+	Challenge          string `json:"challenge,omitempty"`
+	ChallengeSignature string `json:"challenge_signature,omitempty"`
+}
+
+type batchKeyResult struct {
+	KeyFingerprint string    `json:"key_fingerprint,omitempty"`
+	LookupKey      string    `json:"lookup_key,omitempty"`
+	Serial         uint64    `json:"serial,omitempty"`
+	ValidAfter     time.Time `json:"valid_after,omitempty"`
+	ValidBefore    time.Time `json:"valid_before,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// generatedKey carries exactly one of the encryption descriptions
+type generatedKey struct {
+	PublicKey           string              `json:"public_key"`
+	EncryptedPrivateKey []byte              `json:"encrypted_private_key"`
+	RecipientEncryption *crypto.SealedBox   `json:"recipient_encryption,omitempty"`
+	KMSEncryption       *cloud.KMSSealedBox `json:"kms_encryption,omitempty"`
+}
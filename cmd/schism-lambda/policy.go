@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"code.agarg.me/schism/lambda-function/internal/policy"
+)
+
+// policyInit sets up the namespace's principal rules, which are reloaded once
+// PolicyCacheTTL has passed, and its signing profiles
+func policyInit(ns *caNamespace) error {
+	ns.principalRules = &policy.Cached[*policy.RuleSet]{
+		Load: func() (*policy.RuleSet, error) {
+			doc, err := ns.documents.LoadDocument(ns.config.PrincipalRulesSource)
+			if err != nil {
+				return nil, err
+			}
+			return policy.ParseRuleSet(doc)
+		},
+		TTL: ns.config.PolicyCacheTTL,
+	}
+	if err := profilesInit(ns); err != nil {
+		return fmt.Errorf("loading signing profiles from '%s': %w", ns.config.SigningProfilesSource, err)
+	}
+	return nil
+}
+
+// profilesInit loads and validates the namespace's signing profiles once per container
+func profilesInit(ns *caNamespace) error {
+	if ns.signingProfiles != nil || ns.config.SigningProfilesSource == "" {
+		return nil
+	}
+	doc, err := ns.documents.LoadDocument(ns.config.SigningProfilesSource)
+	if err != nil {
+		return err
+	}
+	profiles, err := policy.ParseProfiles(doc)
+	if err != nil {
+		return err
+	}
+	ns.signingProfiles = profiles
+	logger.Printf("Loaded %d signing profiles from '%s'", len(profiles), ns.config.SigningProfilesSource)
+	return nil
+}
+
+// applyProfile replaces the caller supplied certificate shape with the named profile's
+func applyProfile(ns *caNamespace, event *lambdaPayload) error {
+	profile, ok := ns.signingProfiles[event.Profile]
+	if !ok {
+		return fmt.Errorf("unknown signing profile: %s", event.Profile)
+	}
+	if event.CertificateType != "" && event.CertificateType != profile.CertificateType {
+		return fmt.Errorf("the %s profile issues %s certificates, not %s",
+			profile.Name, profile.CertificateType, event.CertificateType)
+	}
+	if event.CriticalOptions != nil || event.Extensions != nil {
+		return fmt.Errorf("the %s profile defines its own critical options and extensions", profile.Name)
+	}
+	principals, err := profile.ResolvePrincipals(event.Principals)
+	if err != nil {
+		return err
+	}
+	event.CertificateType = profile.CertificateType
+	event.Principals = principals
+	event.ValidityInterval = profile.ResolveTTL(event.ValidityInterval)
+	event.CriticalOptions = profile.CriticalOptions
+	event.Extensions = profile.Extensions
+	return nil
+}
+
+// deniedPrincipals is a no-op until a rules source is configured
+func deniedPrincipals(ns *caNamespace, event lambdaPayload) []string {
+	if ns.config.PrincipalRulesSource == "" {
+		return nil
+	}
+	ruleSet, err := ns.principalRules.Get()
+	if err != nil {
+		if ruleSet == nil {
+			errLogger.Panicf("%s\nerror loading principal rules from '%s'", err, ns.config.PrincipalRulesSource)
+		}
+		errLogger.Printf("Error reloading principal rules, using cached copy: %s", err)
+	}
+	return ruleSet.Authorize(event.Identity, event.CertificateType, event.Principals)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPolicyInit(t *testing.T) {
+	documents := fakeDocumentStore{
+		"ssm:rules":    []byte(`{"rules": [{"identity": "alice", "principals": ["alice"]}]}`),
+		"ssm:profiles": []byte(testProfilesYAML),
+	}
+	tests := []struct {
+		name           string
+		rulesSource    string
+		profilesSource string
+		wantProfiles   int
+		wantDenied     []string
+		wantErr        bool
+	}{
+		{name: "rules and profiles", rulesSource: "ssm:rules", profilesSource: "ssm:profiles", wantProfiles: 1, wantDenied: []string{"root"}},
+		{name: "nothing configured"},
+		{name: "missing profiles", profilesSource: "s3://policy/missing.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns, _, _ := testNamespace(t)
+			ns.documents = documents
+			ns.config.PrincipalRulesSource = tt.rulesSource
+			ns.config.SigningProfilesSource = tt.profilesSource
+			if err := policyInit(ns); (err != nil) != tt.wantErr {
+				t.Fatalf("policyInit() error = %v, wantErr %v", err, tt.wantErr)
+			} else if err != nil {
+				return
+			}
+			if len(ns.signingProfiles) != tt.wantProfiles {
+				t.Errorf("policyInit() loaded %d profiles, want %d", len(ns.signingProfiles), tt.wantProfiles)
+			}
+			denied := deniedPrincipals(ns, testSignRequest(t, "alice", "alice", "root"))
+			if fmt.Sprint(denied) != fmt.Sprint(tt.wantDenied) {
+				t.Errorf("deniedPrincipals() = %v, want %v", denied, tt.wantDenied)
+			}
+		})
+	}
+}
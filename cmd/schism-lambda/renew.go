@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+)
+
+// processRenewEvent reissues a stored certificate for the same key and
+// principals, it goes through every current policy check like a new request.
+func processRenewEvent(ns *caNamespace, event lambdaPayload, out *lambdaResponse) {
+	if event.LookupKey == "" {
+		out.Error = "a lookup key is required to renew a certificate"
+		return
+	}
+	record, err := ns.certStore.LoadCertificate(event.LookupKey)
+	if errors.Is(err, cloud.ErrNotFound) {
+		out.Error = "no certificate found for lookup key " + event.LookupKey
+		return
+	} else if err != nil {
+		errLogger.Panicf("%s\nerror loading certificate for '%s'", err, event.LookupKey)
+	}
+	previous, err := record.CheckRenewable(time.Now(), ns.config.RenewalGracePeriod)
+	if err != nil {
+		errLogger.Printf("Refused to renew '%s': %s", event.LookupKey, err)
+		out.Error = err.Error()
+		return
+	}
+	revocations, err := ns.certStore.LoadRevocationList(record.CertificateType)
+	if err != nil {
+		errLogger.Panicf("%s\nerror loading the %s revocation list", err, record.CertificateType)
+	}
+	if revocation := revocations.Revokes(previous); revocation != nil {
+		errLogger.Printf("Refused to renew '%s': serial %d was revoked", event.LookupKey, previous.Serial)
+		out.Error = cloud.ErrCertificateRevoked.Error() + ": " + revocation.Reason
+		return
+	}
+	logger.Printf("Renewing serial %d for '%s'", previous.Serial, record.Identity)
+
+	renewal := event
+	renewal.CertificateType = record.CertificateType
+	renewal.Identity = record.Identity
+	renewal.Principals = previous.ValidPrincipals
+	renewal.PublicKey = string(ssh.MarshalAuthorizedKey(previous.Key))
+	renewal.ValidityInterval = record.ValidityInterval
+	// permissions come from the profile as it is now, or from the request and
+	// the defaults, never from the certificate being replaced
+	renewal.Profile = record.Profile
+	if renewal.Profile != "" {
+		renewal.CriticalOptions = nil
+		renewal.Extensions = nil
+	}
+	renewal.StartsAt = time.Time{}
+	renewal.GenerateKey = ""
+	renewal.Keys = nil
+	processEvent(ns, renewal, out)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+	"code.agarg.me/schism/lambda-function/internal/policy"
+)
+
+const testProfilesYAML = `
+profiles:
+  - name: deploy
+    certificate_type: user
+    principals: [deploy]
+    ttl: 1h
+    extensions:
+      permit-pty: ""
+`
+
+func TestProcessRenewEvent(t *testing.T) {
+	ns, _, certStore := testNamespace(t)
+	profiles, err := policy.ParseProfiles([]byte(testProfilesYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.signingProfiles = profiles
+
+	issue := func(event lambdaPayload) string {
+		out := &lambdaResponse{}
+		processEvent(ns, event, out)
+		if out.Error != "" {
+			t.Fatalf("processEvent() error = %s", out.Error)
+		}
+		return out.LookupKey
+	}
+	restricted := testSignRequest(t, "alice", "alice")
+	restricted.CriticalOptions = map[string]string{crypto.OptionForceCommand: "/usr/bin/true"}
+	restricted.Extensions = map[string]string{}
+	plainKey := issue(restricted)
+
+	fromProfile := testSignRequest(t, "ci")
+	fromProfile.Principals = nil
+	fromProfile.Profile = "deploy"
+	profileKey := issue(fromProfile)
+	// the profile changes after the certificate was issued
+	profiles["deploy"].Extensions = map[string]string{crypto.ExtensionPermitPortForwarding: ""}
+
+	revokedKey := issue(testSignRequest(t, "bob", "bob"))
+	processRevokeEvent(ns, lambdaPayload{LookupKey: revokedKey, Reason: "left the company"}, &lambdaResponse{})
+
+	sealed := testSignRequest(t, "carol", "carol")
+	sealed.EncryptCertificate = true
+	sealedKey := issue(sealed)
+
+	tests := []struct {
+		name           string
+		lookupKey      string
+		wantErr        string
+		wantOptions    map[string]string
+		wantExtensions map[string]string
+	}{
+		{
+			name:           "permissions fall back to the defaults",
+			lookupKey:      plainKey,
+			wantExtensions: crypto.DefaultExtensions(ssh.UserCert),
+		},
+		{
+			name:           "permissions come from the current profile",
+			lookupKey:      profileKey,
+			wantExtensions: map[string]string{crypto.ExtensionPermitPortForwarding: ""},
+		},
+		{name: "revoked", lookupKey: revokedKey, wantErr: "certificate was revoked: left the company"},
+		{name: "sealed", lookupKey: sealedKey, wantErr: "sealed certificates can't be renewed"},
+		{name: "unknown lookup key", lookupKey: "user:nope", wantErr: "no certificate found"},
+		{name: "no lookup key", wantErr: "a lookup key is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var previousSerial uint64
+			if tt.wantErr == "" {
+				_, previous := testStoredCertificate(t, certStore, tt.lookupKey)
+				previousSerial = previous.Serial
+			}
+			out := &lambdaResponse{}
+			processRenewEvent(ns, lambdaPayload{Operation: operationRenew, LookupKey: tt.lookupKey}, out)
+			if (tt.wantErr == "" && out.Error != "") || !strings.Contains(out.Error, tt.wantErr) {
+				t.Fatalf("processRenewEvent() error = %q, want %q", out.Error, tt.wantErr)
+			} else if tt.wantErr != "" {
+				return
+			}
+			if out.LookupKey != tt.lookupKey {
+				t.Errorf("processRenewEvent() lookup key = %s, want %s", out.LookupKey, tt.lookupKey)
+			}
+			_, renewed := testStoredCertificate(t, certStore, tt.lookupKey)
+			if renewed.Serial == previousSerial {
+				t.Errorf("processRenewEvent() did not replace serial %d", previousSerial)
+			}
+			if len(renewed.CriticalOptions) != len(tt.wantOptions) {
+				t.Errorf("renewed critical options = %v, want %v", renewed.CriticalOptions, tt.wantOptions)
+			}
+			if !reflect.DeepEqual(renewed.Extensions, tt.wantExtensions) {
+				t.Errorf("renewed extensions = %v, want %v", renewed.Extensions, tt.wantExtensions)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+)
+
+func processRevokeEvent(ns *caNamespace, event lambdaPayload, out *lambdaResponse) {
+	if event.Reason == "" {
+		errLogger.Panicf("a reason is required to revoke a certificate")
+	}
+	revocation := cloud.Revocation{
+		Serial:    event.Serial,
+		KeyID:     event.KeyID,
+		PublicKey: event.PublicKey,
+		LookupKey: event.LookupKey,
+		Reason:    event.Reason,
+		RevokedOn: time.Now().UTC(),
+	}
+	if revocation.LookupKey == "" && revocation.Serial != 0 {
+		serialRecord, err := ns.certStore.LoadSerialRecord(revocation.Serial)
+		if err != nil && !errors.Is(err, cloud.ErrNotFound) {
+			errLogger.Panicf("%s\nerror looking up serial %d", err, revocation.Serial)
+		} else if err == nil {
+			revocation.LookupKey = serialRecord.LookupKey
+		}
+	}
+
+	certType := event.CertificateType
+	var certRecord *cloud.SignedCertificateRecord
+	if revocation.LookupKey != "" {
+		var err error
+		certRecord, err = ns.certStore.LoadCertificate(revocation.LookupKey)
+		if err != nil {
+			errLogger.Panicf("%s\nerror loading certificate for '%s'", err, revocation.LookupKey)
+		}
+		serial := certRecord.Serial
+		if signedCert, err := certRecord.Certificate(); err == nil {
+			serial = signedCert.Serial
+		} else if err != cloud.ErrCertificateSealed {
+			errLogger.Panicf("%s\nerror parsing certificate for '%s'", err, revocation.LookupKey)
+		}
+		if revocation.Serial != 0 && revocation.Serial != serial {
+			logger.Printf("Serial %d is no longer the current certificate for '%s'", revocation.Serial, revocation.LookupKey)
+			certRecord = nil
+		} else {
+			revocation.Serial = serial
+			certType = certRecord.CertificateType
+			if event.Serial == 0 {
+				// revoking a lookup key covers everything still valid under it
+				revocation.EarlierSerials = certRecord.LiveEarlierSerials(revocation.RevokedOn)
+			}
+		}
+	}
+	if certType == "" {
+		certType = cloud.LookupKeyCertificateType(revocation.LookupKey)
+	}
+	if certType != protocol.HostCertificate && certType != protocol.UserCertificate {
+		errLogger.Panicf("unknown CertificateType (%s) requested", certType)
+	}
+
+	caKeys, err := caPublicKeys(ns, certType)
+	if err != nil {
+		errLogger.Panicf("%s\nerror parsing (%s) CA public key", err, certType)
+	}
+	revocations, err := ns.certStore.RecordRevocation(certType, caKeys, revocation)
+	if err != nil {
+		errLogger.Panicf("%s\nerror recording revocation", err)
+	}
+	logger.Printf("Recorded %s revocation, KRL is now at version %d", certType, revocations.Version)
+
+	if certRecord != nil {
+		certRecord.Revocation = &revocation
+		objKey, err := ns.certStore.SaveCertificate(certRecord)
+		if err != nil {
+			errLogger.Panicf("%s\nerror marking certificate as revoked", err)
+		}
+		logger.Printf("Marked Certificate '%s' as revoked", objKey)
+	}
+	out.LookupKey = revocation.LookupKey
+	out.Revocation = &revocation
+	out.KRLVersion = revocations.Version
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestProcessRevokeEvent(t *testing.T) {
+	ns, _, certStore := testNamespace(t)
+	signed := &lambdaResponse{}
+	processEvent(ns, testSignRequest(t, "alice", "alice"), signed)
+	_, staleCert := testStoredCertificate(t, certStore, signed.LookupKey)
+	// the same identity and principals again replaces the stored certificate
+	processEvent(ns, testSignRequest(t, "alice", "alice"), signed)
+	_, currentCert := testStoredCertificate(t, certStore, signed.LookupKey)
+	reissued := &lambdaResponse{}
+	var bobSerials []uint64
+	for i := 0; i < 3; i++ {
+		processEvent(ns, testSignRequest(t, "bob", "bob"), reissued)
+		_, cert := testStoredCertificate(t, certStore, reissued.LookupKey)
+		bobSerials = append(bobSerials, cert.Serial)
+	}
+
+	tests := []struct {
+		name          string
+		event         lambdaPayload
+		wantLookupKey string
+		wantSerial    uint64
+		wantEarlier   []uint64
+		wantMarked    bool
+	}{
+		{
+			name:          "stale serial",
+			event:         lambdaPayload{Serial: staleCert.Serial, Reason: "rotated"},
+			wantLookupKey: signed.LookupKey,
+			wantSerial:    staleCert.Serial,
+		},
+		{
+			name:          "serial resolved to its lookup key",
+			event:         lambdaPayload{Serial: currentCert.Serial, Reason: "laptop stolen"},
+			wantLookupKey: signed.LookupKey,
+			wantSerial:    currentCert.Serial,
+			wantMarked:    true,
+		},
+		{
+			name:          "lookup key revokes its earlier serials",
+			event:         lambdaPayload{LookupKey: reissued.LookupKey, Reason: "left the company"},
+			wantLookupKey: reissued.LookupKey,
+			wantSerial:    bobSerials[2],
+			wantEarlier:   bobSerials[:2],
+			wantMarked:    true,
+		},
+		{
+			name:       "serial that was never issued",
+			event:      lambdaPayload{Serial: 4242, Reason: "imported certificate"},
+			wantSerial: 4242,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.event.CertificateType = protocol.UserCertificate
+			out := &lambdaResponse{}
+			processRevokeEvent(ns, tt.event, out)
+			if out.LookupKey != tt.wantLookupKey || out.Revocation.Serial != tt.wantSerial {
+				t.Errorf("processRevokeEvent() revoked serial %d of '%s', want %d of '%s'",
+					out.Revocation.Serial, out.LookupKey, tt.wantSerial, tt.wantLookupKey)
+			}
+			if fmt.Sprint(out.Revocation.EarlierSerials) != fmt.Sprint(tt.wantEarlier) {
+				t.Errorf("processRevokeEvent() revoked earlier serials %v, want %v", out.Revocation.EarlierSerials, tt.wantEarlier)
+			}
+			if out.KRLVersion != uint64(i+1) || certStore.krlVersions[protocol.UserCertificate] != out.KRLVersion {
+				t.Errorf("processRevokeEvent() KRL version = %d, published %d, want %d",
+					out.KRLVersion, certStore.krlVersions[protocol.UserCertificate], i+1)
+			}
+			if tt.wantLookupKey == "" {
+				return
+			}
+			record, _ := testStoredCertificate(t, certStore, tt.wantLookupKey)
+			if marked := record.Revocation != nil; marked != tt.wantMarked {
+				t.Errorf("processRevokeEvent() marked the stored certificate revoked = %v, want %v", marked, tt.wantMarked)
+			}
+		})
+	}
+	wantPanic(t, "processRevokeEvent() without a reason", func() {
+		processRevokeEvent(ns, lambdaPayload{Serial: currentCert.Serial}, &lambdaResponse{})
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func processRotateEvent(ns *caNamespace, event lambdaPayload, out *lambdaResponse) {
+	certType := event.CertificateType
+	if certType != protocol.HostCertificate && certType != protocol.UserCertificate {
+		errLogger.Panicf("unknown CertificateType (%s) requested", certType)
+	}
+	// rotate a copy, a store that refuses the save may have cached the original
+	keyRing := *ns.keyPairs[string(certType)]
+	if err := keyRing.Rotate(event.RotationStep, ns.config.CaKeyAlgorithm); err != nil {
+		errLogger.Panicf("%s\nerror rotating the (%s) CA", err, certType)
+	}
+	if err := ns.caStore.SaveKeyRing(certType, &keyRing); err != nil {
+		errLogger.Panicf("%s\nerror saving the (%s) CA key ring", err, certType)
+	}
+	// publish what was actually stored, verified like any other load
+	saved, err := ns.caStore.LoadKeyRing(certType)
+	if err != nil {
+		errLogger.Panicf("%s\nerror reloading the rotated (%s) CA key ring", err, certType)
+	}
+	ns.keyPairs[string(certType)] = saved
+	logger.Printf("(%s) CA is now %s, signing with %s", certType, saved.State(), saved.Current.Fingerprint)
+	if err := publishCA(ns, certType); err != nil {
+		errLogger.Panicf("%s\nerror publishing the (%s) CA", err, certType)
+	}
+	if err := publishKRL(ns, certType); err != nil {
+		errLogger.Panicf("%s\nerror publishing the (%s) KRL", err, certType)
+	}
+	out.CAState = saved.State()
+	out.TrustedFingerprints = saved.Fingerprints()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestProcessRotateEvent(t *testing.T) {
+	ns, caStore, certStore := testNamespace(t)
+	original := ns.keyPairs[string(protocol.UserCertificate)].Current.Fingerprint
+	tests := []struct {
+		step      string
+		wantState string
+		wantKeys  int
+	}{
+		{step: crypto.RotationStepGenerate, wantState: crypto.RotationPending, wantKeys: 2},
+		{step: crypto.RotationStepPromote, wantState: crypto.RotationPromoted, wantKeys: 2},
+		{step: crypto.RotationStepRetire, wantState: crypto.RotationIdle, wantKeys: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			loads := caStore.loads
+			event := lambdaPayload{RotationStep: tt.step}
+			event.CertificateType = protocol.UserCertificate
+			out := &lambdaResponse{}
+			processRotateEvent(ns, event, out)
+			if out.CAState != tt.wantState || len(out.TrustedFingerprints) != tt.wantKeys {
+				t.Errorf("processRotateEvent() = %s trusting %v, want %s with %d keys",
+					out.CAState, out.TrustedFingerprints, tt.wantState, tt.wantKeys)
+			}
+			if caStore.loads != loads+1 {
+				t.Errorf("processRotateEvent() made %d key ring loads, want the one reload", caStore.loads-loads)
+			}
+			cached := ns.keyPairs[string(protocol.UserCertificate)]
+			if cached.State() != tt.wantState || cached == caStore.keyRings[protocol.UserCertificate] {
+				t.Errorf("processRotateEvent() kept a %s ring, want a reloaded %s ring", cached.State(), tt.wantState)
+			}
+			if certStore.caObjects[protocol.UserCertificate] == nil {
+				t.Errorf("processRotateEvent() did not publish the rotated CA")
+			}
+		})
+	}
+	if ns.keyPairs[string(protocol.UserCertificate)].Current.Fingerprint == original {
+		t.Errorf("processRotateEvent() is still signing with the original CA")
+	}
+}
+
+// TestProcessRotateEvent_OtherContainer rotates from one container while a
+// second one, sharing the same stores, keeps signing host certificates
+func TestProcessRotateEvent_OtherContainer(t *testing.T) {
+	rotator, caStore, certStore := testNamespace(t)
+	signer := &caNamespace{name: rotator.name, config: rotator.config, caStore: caStore, certStore: certStore}
+	original := rotator.keyPairs[string(protocol.HostCertificate)].Current.Fingerprint
+	signHost := func() *ssh.Certificate {
+		t.Helper()
+		event := testSignRequest(t, "web", "web.example.com")
+		event.CertificateType = protocol.HostCertificate
+		out, err := handleEvent(signer, event)
+		if err != nil || out.Error != "" {
+			t.Fatalf("handleEvent() = %q, %v", out.Error, err)
+		}
+		_, cert := testStoredCertificate(t, certStore, out.LookupKey)
+		return cert
+	}
+	rotate := func(step string) {
+		t.Helper()
+		event := lambdaPayload{Operation: operationRotateCA, RotationStep: step}
+		event.CertificateType = protocol.HostCertificate
+		if _, err := handleEvent(rotator, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	published := func() string {
+		return string(certStore.caObjects[protocol.HostCertificate].AuthorizedKey)
+	}
+
+	signHost()
+	rotate(crypto.RotationStepGenerate)
+	pending := caStore.keyRings[protocol.HostCertificate].Trusted()
+	if cert := signHost(); ssh.FingerprintSHA256(cert.SignatureKey) != original {
+		t.Errorf("pending rotation signed with %s, want %s", ssh.FingerprintSHA256(cert.SignatureKey), original)
+	}
+	for _, keyPair := range pending {
+		if !strings.Contains(published(), strings.TrimSpace(string(keyPair.AuthorizedKey))) {
+			t.Errorf("published host CAs dropped %s while it was pending", keyPair.Fingerprint)
+		}
+	}
+
+	rotate(crypto.RotationStepPromote)
+	rotate(crypto.RotationStepRetire)
+	current := caStore.keyRings[protocol.HostCertificate].Current
+	if cert := signHost(); ssh.FingerprintSHA256(cert.SignatureKey) != current.Fingerprint {
+		t.Errorf("signed with %s after the rotation, want %s", ssh.FingerprintSHA256(cert.SignatureKey), current.Fingerprint)
+	}
+	for _, keyPair := range pending {
+		if keyPair.Fingerprint == original && strings.Contains(published(), strings.TrimSpace(string(keyPair.AuthorizedKey))) {
+			t.Errorf("published host CAs still trust the retired %s", original)
+		}
+	}
+	if got := signer.keyPairs[string(protocol.HostCertificate)].Fingerprints(); len(got) != 1 || got[0] != current.Fingerprint {
+		t.Errorf("second container trusts %v, want only %s", got, current.Fingerprint)
+	}
+}
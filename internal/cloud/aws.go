@@ -5,8 +5,14 @@ import (
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/ssm"
@@ -24,16 +30,25 @@ func LoadCAFromSSM(ssmSvc ssmiface.SSMAPI, paramName string) (*schismCrypt.Encod
 	if err != nil {
 		return nil, err
 	}
-	rawCaPair := []byte(*ssmOutput.Parameter.Value)
+	return decodeCAParameter(paramName, *ssmOutput.Parameter.Value)
+}
+
+func decodeCAParameter(paramName string, value string) (*schismCrypt.EncodedCaPair, error) {
 	caPair := &schismCrypt.EncodedCaPair{}
-	if err := json.Unmarshal(rawCaPair, caPair); err != nil {
+	if err := json.Unmarshal([]byte(value), caPair); err != nil {
 		return nil, err
 	}
+	if err := caPair.Verify(); err != nil {
+		return nil, fmt.Errorf("CA in '%s' failed verification: %w", paramName, err)
+	}
 	return caPair, nil
-
 }
 
 func SaveCAToSSM(ssmSvc ssmiface.SSMAPI, caPair *schismCrypt.EncodedCaPair, caParamName string, ssmKmsKeyId string) error {
+	return putCAParameter(ssmSvc, caPair, caParamName, ssmKmsKeyId, false)
+}
+
+func putCAParameter(ssmSvc ssmiface.SSMAPI, caPair *schismCrypt.EncodedCaPair, caParamName string, ssmKmsKeyId string, overwrite bool) error {
 	caPairJson, err := json.Marshal(caPair)
 	if err != nil {
 		return err
@@ -47,29 +62,183 @@ func SaveCAToSSM(ssmSvc ssmiface.SSMAPI, caPair *schismCrypt.EncodedCaPair, caPa
 	if len(ssmKmsKeyId) > 0 {
 		putParamInput.KeyId = aws.String(ssmKmsKeyId)
 	}
+	if overwrite {
+		putParamInput.Overwrite = aws.Bool(true)
+	}
 	_, err = ssmSvc.PutParameter(putParamInput)
 	return err
 }
 
+const (
+	caPendingParamSuffix  = "-pending"
+	caPreviousParamSuffix = "-previous"
+)
+
+// LoadCaKeyRingFromSSM reads the signing CA from paramName and any rotation
+// keys from the "-pending" and "-previous" parameters next to it, all in a
+// single GetParameters call.
+func LoadCaKeyRingFromSSM(ssmSvc ssmiface.SSMAPI, paramName string) (*schismCrypt.CaKeyRing, error) {
+	pendingName := paramName + caPendingParamSuffix
+	previousName := paramName + caPreviousParamSuffix
+	ssmOutput, err := ssmSvc.GetParameters(&ssm.GetParametersInput{
+		Names:          aws.StringSlice([]string{paramName, pendingName, previousName}),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	caPairs := map[string]*schismCrypt.EncodedCaPair{}
+	for _, param := range ssmOutput.Parameters {
+		name := aws.StringValue(param.Name)
+		if caPairs[name], err = decodeCAParameter(name, aws.StringValue(param.Value)); err != nil {
+			return nil, err
+		}
+	}
+	if caPairs[paramName] == nil {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, fmt.Sprintf("parameter %s not found", paramName), nil)
+	}
+	return &schismCrypt.CaKeyRing{
+		Current:  caPairs[paramName],
+		Pending:  caPairs[pendingName],
+		Previous: caPairs[previousName],
+	}, nil
+}
+
+// SaveCaKeyRingToSSM writes every trusted key before deleting the slots that
+// are now empty, so an interrupted save never loses a CA.
+func SaveCaKeyRingToSSM(ssmSvc ssmiface.SSMAPI, ring *schismCrypt.CaKeyRing, paramName string, ssmKmsKeyId string) error {
+	if ring.Current == nil {
+		return errors.New("refusing to save a CA key ring without a current CA")
+	}
+	slots := []struct {
+		name   string
+		caPair *schismCrypt.EncodedCaPair
+	}{
+		{paramName + caPreviousParamSuffix, ring.Previous},
+		{paramName + caPendingParamSuffix, ring.Pending},
+		{paramName, ring.Current},
+	}
+	for _, slot := range slots {
+		if slot.caPair == nil {
+			continue
+		}
+		if err := putCAParameter(ssmSvc, slot.caPair, slot.name, ssmKmsKeyId, true); err != nil {
+			return err
+		}
+	}
+	for _, slot := range slots {
+		if slot.caPair != nil {
+			continue
+		}
+		_, err := ssmSvc.DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String(slot.name)})
+		if err != nil && !IsSSMNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func IsSSMNotFound(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == ssm.ErrCodeParameterNotFound
+}
+
+var ErrS3PreconditionFailed = errors.New("s3 object was modified concurrently")
+
 func SaveS3Object(s3Svc s3iface.S3API, config SchismConfig, s3Object protocol.S3Object) (string, error) {
 	jsonBody, err := json.Marshal(s3Object)
 	if err != nil {
 		return "", err
 	}
+	return SaveS3Bytes(s3Svc, config, s3Object.ObjectKey(config.CertsS3Prefix), jsonBody)
+}
 
-	md5Bytes := md5.Sum(jsonBody)
+func SaveS3Bytes(s3Svc s3iface.S3API, config SchismConfig, objectKey string, body []byte) (string, error) {
+	_, err := s3Svc.PutObject(s3PutInput(config, objectKey, body))
+	if err != nil {
+		return "", err
+	}
+	return objectKey, nil
+}
+
+// SaveS3ObjectIfMatch only writes s3Object if the stored copy still has etag,
+// an empty etag means the object must not exist yet.
+func SaveS3ObjectIfMatch(s3Svc s3iface.S3API, config SchismConfig, s3Object protocol.S3Object, etag string) (string, error) {
+	jsonBody, err := json.Marshal(s3Object)
+	if err != nil {
+		return "", err
+	}
+	return SaveS3BytesIfMatch(s3Svc, config, s3Object.ObjectKey(config.CertsS3Prefix), jsonBody, etag)
+}
+
+func SaveS3BytesIfMatch(s3Svc s3iface.S3API, config SchismConfig, objectKey string, body []byte, etag string) (string, error) {
+	putObjectInput := s3PutInput(config, objectKey, body)
+	condition := map[string]string{"If-None-Match": "*"}
+	if etag != "" {
+		condition = map[string]string{"If-Match": etag}
+	}
+	_, err := s3Svc.PutObjectWithContext(aws.BackgroundContext(), putObjectInput, request.WithSetRequestHeaders(condition))
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) &&
+			(reqErr.StatusCode() == http.StatusPreconditionFailed || reqErr.StatusCode() == http.StatusConflict) {
+			return "", ErrS3PreconditionFailed
+		}
+		return "", err
+	}
+	return *putObjectInput.Key, nil
+}
+
+func s3PutInput(config SchismConfig, objectKey string, body []byte) *s3.PutObjectInput {
+	md5Bytes := md5.Sum(body)
 	contentMd5 := base64.StdEncoding.EncodeToString(md5Bytes[:])
 
-	objectKey := s3Object.ObjectKey(config.CertsS3Prefix)
-	putObjectInput := s3.PutObjectInput{
-		Body:       bytes.NewReader(jsonBody),
+	return &s3.PutObjectInput{
+		Body:       bytes.NewReader(body),
 		Bucket:     aws.String(config.CertsS3Bucket),
 		Key:        aws.String(objectKey),
 		ContentMD5: aws.String(contentMd5),
 	}
-	_, err = s3Svc.PutObject(&putObjectInput)
+}
+
+// LoadS3Object fills s3Object from the bucket and returns the ETag it was read at.
+func LoadS3Object(s3Svc s3iface.S3API, config SchismConfig, s3Object protocol.S3Object) (string, error) {
+	return LoadS3Key(s3Svc, config, s3Object.ObjectKey(config.CertsS3Prefix), s3Object)
+}
+
+// LoadS3Bytes returns the raw object and the ETag it was read at
+func LoadS3Bytes(s3Svc s3iface.S3API, config SchismConfig, objectKey string) ([]byte, string, error) {
+	getObjectOutput, err := s3Svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(config.CertsS3Bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer getObjectOutput.Body.Close()
+	body, err := io.ReadAll(getObjectOutput.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, aws.StringValue(getObjectOutput.ETag), nil
+}
+
+func LoadS3Key(s3Svc s3iface.S3API, config SchismConfig, objectKey string, out interface{}) (string, error) {
+	getObjectOutput, err := s3Svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(config.CertsS3Bucket),
+		Key:    aws.String(objectKey),
+	})
 	if err != nil {
 		return "", err
 	}
-	return objectKey, nil
+	defer getObjectOutput.Body.Close()
+	if err := json.NewDecoder(getObjectOutput.Body).Decode(out); err != nil {
+		return "", err
+	}
+	return aws.StringValue(getObjectOutput.ETag), nil
+}
+
+func IsS3NotFound(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey
 }
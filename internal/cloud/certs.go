@@ -0,0 +1,190 @@
+package cloud
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	schismCrypt "code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+// SignedCertificateRecord is what actually lands in the certs bucket: the
+// protocol object clients read, plus the bookkeeping needed for revocation.
+// A sealed record leaves Identity and Principals empty and sets
+// SignedCertificateEncryption, the protocol type has no fields for the scheme
+// so that lives in Sealing.
+type SignedCertificateRecord struct {
+	protocol.SignedCertificateS3Object
+	LookupKey  string      `json:"lookup_key,omitempty"`
+	Serial     uint64      `json:"serial,omitempty"`
+	Revocation *Revocation `json:"revocation,omitempty"`
+	// Profile is the signing profile the certificate was issued from, renewals
+	// resolve it again
+	Profile string `json:"profile,omitempty"`
+
+	// Sealing describes how a sealed RawSignedCertificate is opened
+	Sealing *schismCrypt.SealedBox `json:"signed_certificate_sealing,omitempty"`
+
+	ValidBefore time.Time `json:"valid_before,omitempty"`
+	// EarlierSerials were issued to LookupKey before Serial and may still be
+	// valid, revoking the lookup key revokes them too
+	EarlierSerials []IssuedSerial `json:"earlier_serials,omitempty"`
+}
+
+type IssuedSerial struct {
+	Serial uint64 `json:"serial"`
+	// ValidBefore is zero when it isn't known, the serial is then kept
+	ValidBefore time.Time `json:"valid_before,omitempty"`
+}
+
+var (
+	ErrCertificateSealed  = errors.New("certificate is sealed to the requester")
+	ErrCertificateRevoked = errors.New("certificate was revoked")
+	ErrRenewalGracePassed = errors.New("certificate is past its renewal grace period")
+	ErrSealedNotRenewable = errors.New("sealed certificates can't be renewed, request a new certificate instead")
+)
+
+// Seal encrypts the certificate to recipient and drops the identity and
+// principals, leaving nothing in the bucket that says who may log in where
+func (r *SignedCertificateRecord) Seal(recipient ssh.PublicKey) error {
+	box, ciphertext, err := schismCrypt.Seal(recipient, r.RawSignedCertificate)
+	if err != nil {
+		return err
+	}
+	if r.LookupKey == "" {
+		r.LookupKey = protocol.GenerateLookupKey(r.Identity, r.Principals, r.CertificateType).String()
+	}
+	r.Identity = ""
+	r.Principals = nil
+	r.Profile = ""
+	r.RawSignedCertificate = ciphertext
+	r.SignedCertificateEncryption = &protocol.SignedCertificateEncryption{}
+	r.Sealing = box
+	return nil
+}
+
+func (r *SignedCertificateRecord) sealed() bool {
+	return r.Sealing != nil || r.SignedCertificateEncryption != nil
+}
+
+// Supersede carries the serials of previous, the record r replaces, that
+// haven't expired by now. A revoked record has nothing left to carry.
+func (r *SignedCertificateRecord) Supersede(previous *SignedCertificateRecord, now time.Time) {
+	if previous == nil || previous.Revocation != nil {
+		return
+	}
+	issued := append(append([]IssuedSerial{}, previous.EarlierSerials...),
+		IssuedSerial{Serial: previous.Serial, ValidBefore: previous.validBefore()})
+	for _, earlier := range issued {
+		if earlier.Serial == 0 || earlier.Serial == r.Serial {
+			continue
+		}
+		if !earlier.ValidBefore.IsZero() && !now.Before(earlier.ValidBefore) {
+			continue
+		}
+		r.EarlierSerials = append(r.EarlierSerials, earlier)
+	}
+}
+
+// LiveEarlierSerials are the EarlierSerials that haven't expired by now
+func (r *SignedCertificateRecord) LiveEarlierSerials(now time.Time) []uint64 {
+	var serials []uint64
+	for _, earlier := range r.EarlierSerials {
+		if earlier.ValidBefore.IsZero() || now.Before(earlier.ValidBefore) {
+			serials = append(serials, earlier.Serial)
+		}
+	}
+	return serials
+}
+
+func (r *SignedCertificateRecord) validBefore() time.Time {
+	if !r.ValidBefore.IsZero() {
+		return r.ValidBefore
+	}
+	if cert, err := r.Certificate(); err == nil {
+		return time.Unix(int64(cert.ValidBefore), 0).UTC()
+	}
+	return time.Time{}
+}
+
+// ObjectKey prefers the recorded LookupKey, batch certificates don't live at
+// the identity derived key
+func (r *SignedCertificateRecord) ObjectKey(prefix string) string {
+	if r.LookupKey == "" {
+		return r.SignedCertificateS3Object.ObjectKey(prefix)
+	}
+	return SignedCertificateObjectKey(prefix, r.LookupKey)
+}
+
+func SignedCertificateObjectKey(prefix string, lookupKey string) string {
+	return fmt.Sprintf("%sSigned-Certs/%s.json", prefix, lookupKey)
+}
+
+// BatchLookupKey tells apart the certificates of a batch, which share an
+// identity and principals but not a public key
+func BatchLookupKey(identity string, principals []string, certType protocol.CertificateType, pubKey ssh.PublicKey) string {
+	lookupKey := protocol.GenerateLookupKey(identity, principals, certType).String()
+	sum := sha256.Sum256([]byte(lookupKey + ":" + ssh.FingerprintSHA256(pubKey)))
+	return fmt.Sprintf("%s:%s", certType, hex.EncodeToString(sum[:]))
+}
+
+func (r *SignedCertificateRecord) Certificate() (*ssh.Certificate, error) {
+	if r.sealed() {
+		return nil, ErrCertificateSealed
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(r.RawSignedCertificate)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("stored %s is not a certificate", pubKey.Type())
+	}
+	return cert, nil
+}
+
+// CheckRenewable returns the stored certificate as long as it wasn't revoked
+// and expired no more than gracePeriod before now. Sealed certificates are
+// refused, the key and principals a renewal needs are only in the ciphertext.
+func (r *SignedCertificateRecord) CheckRenewable(now time.Time, gracePeriod time.Duration) (*ssh.Certificate, error) {
+	if r.Revocation != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCertificateRevoked, r.Revocation.Reason)
+	}
+	if r.sealed() {
+		return nil, fmt.Errorf("%w: %s", ErrSealedNotRenewable, ErrCertificateSealed)
+	}
+	cert, err := r.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	expiredOn := time.Unix(int64(cert.ValidBefore), 0).UTC()
+	if now.After(expiredOn.Add(gracePeriod)) {
+		return nil, fmt.Errorf("%w: it expired on %s", ErrRenewalGracePassed, expiredOn.Format(time.RFC3339))
+	}
+	return cert, nil
+}
+
+func LoadSignedCertificate(s3Svc s3iface.S3API, config SchismConfig, lookupKey string) (*SignedCertificateRecord, string, error) {
+	record := &SignedCertificateRecord{}
+	etag, err := LoadS3Key(s3Svc, config, SignedCertificateObjectKey(config.CertsS3Prefix, lookupKey), record)
+	if err != nil {
+		return nil, "", err
+	}
+	return record, etag, nil
+}
+
+func LookupKeyCertificateType(lookupKey string) protocol.CertificateType {
+	certType := protocol.CertificateType(strings.SplitN(lookupKey, ":", 2)[0])
+	if certType != protocol.HostCertificate && certType != protocol.UserCertificate {
+		return ""
+	}
+	return certType
+}
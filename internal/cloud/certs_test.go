@@ -0,0 +1,300 @@
+package cloud
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestSignedCertificateObjectKey(t *testing.T) {
+	s3Object := &protocol.SignedCertificateS3Object{
+		CertificateType: protocol.UserCertificate,
+		Identity:        "user@test.schism.example.com",
+		Principals:      []string{"user1", "app_user"},
+	}
+	lookupKey := protocol.GenerateLookupKey(s3Object.Identity, s3Object.Principals, s3Object.CertificateType).String()
+	if got, want := SignedCertificateObjectKey("test/", lookupKey), s3Object.ObjectKey("test/"); got != want {
+		t.Errorf("SignedCertificateObjectKey() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadSignedCertificate(t *testing.T) {
+	config := SchismConfig{CertsS3Bucket: "schism-test", CertsS3Prefix: "test/"}
+	ca, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	caSigner, _ := ca.Signer()
+	userKey, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	signedCert, err := crypto.Sign(&crypto.SigningReq{
+		PublicKey: userKey.AuthorizedKey, CertType: ssh.UserCert, Identity: "alice",
+		Principals: []string{"alice"}, TTL: time.Hour, Serials: crypto.NewMemorySerialAllocator(7),
+	}, caSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &SignedCertificateRecord{
+		SignedCertificateS3Object: protocol.SignedCertificateS3Object{
+			CertificateType:      protocol.UserCertificate,
+			Identity:             "alice",
+			Principals:           []string{"alice"},
+			RawSignedCertificate: crypto.MarshalSignedCert(signedCert),
+		},
+		Serial: signedCert.Serial,
+	}
+	s3Svc := newFakeS3Client()
+	if _, err := SaveS3Object(s3Svc, config, record); err != nil {
+		t.Fatal(err)
+	}
+	lookupKey := protocol.GenerateLookupKey("alice", []string{"alice"}, protocol.UserCertificate).String()
+
+	got, _, err := LoadSignedCertificate(s3Svc, config, lookupKey)
+	if err != nil {
+		t.Fatalf("LoadSignedCertificate() error = %v", err)
+	}
+	if got.Serial != 7 || got.Identity != "alice" {
+		t.Errorf("LoadSignedCertificate() got = %+v", got)
+	}
+	cert, err := got.Certificate()
+	if err != nil || cert.Serial != 7 {
+		t.Errorf("Certificate() got serial = %v, err = %v, want 7", cert, err)
+	}
+	if _, _, err := LoadSignedCertificate(s3Svc, config, "user:missing"); !IsS3NotFound(err) {
+		t.Errorf("LoadSignedCertificate() error = %v, wanted NoSuchKey", err)
+	}
+}
+
+func TestLookupKeyCertificateType(t *testing.T) {
+	tests := []struct {
+		lookupKey string
+		want      protocol.CertificateType
+	}{
+		{lookupKey: "user:abc", want: protocol.UserCertificate},
+		{lookupKey: "host:abc", want: protocol.HostCertificate},
+		{lookupKey: "robot:abc", want: ""},
+		{lookupKey: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lookupKey, func(t *testing.T) {
+			if got := LookupKeyCertificateType(tt.lookupKey); got != tt.want {
+				t.Errorf("LookupKeyCertificateType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchLookupKey(t *testing.T) {
+	edKey, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	ecKey, _ := crypto.CreateCA(crypto.CAKeyAlgoECDSAP256)
+	edPubKey, _ := crypto.LazyParseAuthorizedKey(edKey.AuthorizedKey)
+	ecPubKey, _ := crypto.LazyParseAuthorizedKey(ecKey.AuthorizedKey)
+	principals := []string{"web1.example.com"}
+
+	edLookupKey := BatchLookupKey("web1", principals, protocol.HostCertificate, edPubKey)
+	if got := BatchLookupKey("web1", principals, protocol.HostCertificate, ecPubKey); got == edLookupKey {
+		t.Errorf("BatchLookupKey() = %v for both keys", got)
+	}
+	if got := BatchLookupKey("web1", principals, protocol.HostCertificate, edPubKey); got != edLookupKey {
+		t.Errorf("BatchLookupKey() = %v, want the stable %v", got, edLookupKey)
+	}
+	if got := LookupKeyCertificateType(edLookupKey); got != protocol.HostCertificate {
+		t.Errorf("LookupKeyCertificateType(%v) = %v, want host", edLookupKey, got)
+	}
+	singleLookupKey := protocol.GenerateLookupKey("web1", principals, protocol.HostCertificate).String()
+	if edLookupKey == singleLookupKey {
+		t.Errorf("BatchLookupKey() collides with the single key lookup key")
+	}
+}
+
+func TestSignedCertificateRecord_ObjectKey(t *testing.T) {
+	s3Object := protocol.SignedCertificateS3Object{
+		CertificateType: protocol.HostCertificate,
+		Identity:        "web1",
+		Principals:      []string{"web1.example.com"},
+	}
+	tests := []struct {
+		name   string
+		record *SignedCertificateRecord
+		want   string
+	}{
+		{
+			name:   "falls back to the identity derived key",
+			record: &SignedCertificateRecord{SignedCertificateS3Object: s3Object},
+			want:   s3Object.ObjectKey("test/"),
+		},
+		{
+			name:   "uses the recorded lookup key",
+			record: &SignedCertificateRecord{SignedCertificateS3Object: s3Object, LookupKey: "host:batch"},
+			want:   "test/Signed-Certs/host:batch.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.record.ObjectKey("test/"); got != tt.want {
+				t.Errorf("ObjectKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedCertificateRecord_Seal(t *testing.T) {
+	config := SchismConfig{CertsS3Bucket: "schism-test", CertsS3Prefix: "test/"}
+	requesterPub, requesterPriv, _ := ed25519.GenerateKey(rand.Reader)
+	requester, _ := ssh.NewPublicKey(requesterPub)
+	rawCert := []byte("ssh-ed25519-cert-v01@openssh.com AAAA alice")
+	record := &SignedCertificateRecord{
+		SignedCertificateS3Object: protocol.SignedCertificateS3Object{
+			CertificateType:      protocol.UserCertificate,
+			Identity:             "alice",
+			Principals:           []string{"alice", "root"},
+			RawSignedCertificate: rawCert,
+		},
+		Serial:  7,
+		Profile: "prod-admin",
+	}
+	if err := record.Seal(requester); err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	s3Svc := newFakeS3Client()
+	if _, err := SaveS3Object(s3Svc, config, record); err != nil {
+		t.Fatal(err)
+	}
+	lookupKey := protocol.GenerateLookupKey("alice", []string{"alice", "root"}, protocol.UserCertificate).String()
+	got, _, err := LoadSignedCertificate(s3Svc, config, lookupKey)
+	if err != nil {
+		t.Fatalf("LoadSignedCertificate() error = %v", err)
+	}
+	if got.Identity != "" || got.Principals != nil || got.Profile != "" {
+		t.Errorf("sealed record still names %s with principals %s from profile %s", got.Identity, got.Principals, got.Profile)
+	}
+	if got.Sealing == nil || got.Sealing.Scheme != crypto.SealSchemeX25519 {
+		t.Fatalf("sealed record sealing = %+v", got.Sealing)
+	}
+	if _, err := got.Certificate(); err != ErrCertificateSealed {
+		t.Errorf("Certificate() error = %v, want %v", err, ErrCertificateSealed)
+	}
+	opened, err := got.Sealing.Open(requesterPriv, got.RawSignedCertificate)
+	if err != nil || string(opened) != string(rawCert) {
+		t.Errorf("Open() got = %q, err = %v, want %q", opened, err, rawCert)
+	}
+
+	// clients that only know the protocol object must still see it is sealed
+	raw, _ := json.Marshal(record)
+	clientObject := &protocol.SignedCertificateS3Object{}
+	if err := json.Unmarshal(raw, clientObject); err != nil {
+		t.Fatal(err)
+	}
+	if clientObject.SignedCertificateEncryption == nil {
+		t.Errorf("sealed record reads as unencrypted through the protocol object")
+	}
+}
+
+func TestSignedCertificateRecord_Supersede(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	live := now.Add(time.Hour)
+	expired := now.Add(-time.Hour)
+	tests := []struct {
+		name     string
+		previous *SignedCertificateRecord
+		want     []IssuedSerial
+	}{
+		{name: "nothing replaced"},
+		{
+			name:     "previous still valid",
+			previous: &SignedCertificateRecord{Serial: 3, ValidBefore: live},
+			want:     []IssuedSerial{{Serial: 3, ValidBefore: live}},
+		},
+		{
+			name: "expired serials are dropped",
+			previous: &SignedCertificateRecord{Serial: 3, ValidBefore: live, EarlierSerials: []IssuedSerial{
+				{Serial: 1, ValidBefore: expired}, {Serial: 2, ValidBefore: live},
+			}},
+			want: []IssuedSerial{{Serial: 2, ValidBefore: live}, {Serial: 3, ValidBefore: live}},
+		},
+		{
+			name:     "unknown validity is kept",
+			previous: &SignedCertificateRecord{Serial: 3, Sealing: &crypto.SealedBox{}},
+			want:     []IssuedSerial{{Serial: 3}},
+		},
+		{
+			name: "revoked previous",
+			previous: &SignedCertificateRecord{Serial: 3, ValidBefore: live, Revocation: &Revocation{Serial: 3},
+				EarlierSerials: []IssuedSerial{{Serial: 2, ValidBefore: live}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &SignedCertificateRecord{Serial: 4}
+			record.Supersede(tt.previous, now)
+			if !reflect.DeepEqual(record.EarlierSerials, tt.want) {
+				t.Errorf("Supersede() earlier serials = %v, want %v", record.EarlierSerials, tt.want)
+			}
+		})
+	}
+	record := &SignedCertificateRecord{EarlierSerials: []IssuedSerial{{Serial: 1, ValidBefore: expired}, {Serial: 2}}}
+	if got := record.LiveEarlierSerials(now); !reflect.DeepEqual(got, []uint64{2}) {
+		t.Errorf("LiveEarlierSerials() = %v, want [2]", got)
+	}
+}
+
+func TestSignedCertificateRecord_CheckRenewable(t *testing.T) {
+	ca, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	caSigner, _ := ca.Signer()
+	userKey, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	issuedAt := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	cert, err := crypto.Sign(&crypto.SigningReq{
+		PublicKey: userKey.AuthorizedKey, CertType: ssh.UserCert, Identity: "alice",
+		Principals: []string{"alice"}, TTL: time.Hour, Clock: func() time.Time { return issuedAt },
+	}, caSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRecord := func() *SignedCertificateRecord {
+		return &SignedCertificateRecord{
+			SignedCertificateS3Object: protocol.SignedCertificateS3Object{
+				CertificateType:      protocol.UserCertificate,
+				Identity:             "alice",
+				Principals:           []string{"alice"},
+				RawSignedCertificate: crypto.MarshalSignedCert(cert),
+			},
+		}
+	}
+	revoked := newRecord()
+	revoked.Revocation = &Revocation{Reason: "laptop stolen"}
+	requesterPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	requester, _ := ssh.NewPublicKey(requesterPub)
+	sealed := newRecord()
+	if err := sealed.Seal(requester); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		record  *SignedCertificateRecord
+		now     time.Time
+		wantErr error
+	}{
+		{name: "still valid", record: newRecord(), now: issuedAt.Add(30 * time.Minute)},
+		{name: "expired within the grace period", record: newRecord(), now: issuedAt.Add(2 * time.Hour)},
+		{name: "past the grace period", record: newRecord(), now: issuedAt.Add(26 * time.Hour), wantErr: ErrRenewalGracePassed},
+		{name: "revoked", record: revoked, now: issuedAt, wantErr: ErrCertificateRevoked},
+		{name: "sealed", record: sealed, now: issuedAt, wantErr: ErrSealedNotRenewable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.record.CheckRenewable(tt.now, 24*time.Hour)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckRenewable() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Serial != cert.Serial {
+				t.Errorf("CheckRenewable() serial = %d, want %d", got.Serial, cert.Serial)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package cloud
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
+
+	schismCrypt "code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+const challengeNonceBytes = 32
+
+var (
+	ErrChallengeExpired   = errors.New("proof of possession challenge has expired")
+	ErrChallengeConsumed  = errors.New("proof of possession challenge was already used")
+	ErrChallengeMalformed = errors.New("proof of possession challenge is not one this CA issued")
+)
+
+// ChallengeS3Object is a single use nonce the requester has to sign with the
+// private half of KeyFingerprint before a certificate is issued for it
+type ChallengeS3Object struct {
+	Nonce          string     `json:"nonce"`
+	KeyFingerprint string     `json:"key_fingerprint"`
+	IssuedOn       time.Time  `json:"issued_on"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	ConsumedOn     *time.Time `json:"consumed_on,omitempty"`
+}
+
+func (c *ChallengeS3Object) ObjectKey(prefix string) string {
+	return prefix + "Challenges/" + c.Nonce + ".json"
+}
+
+func IssueChallenge(s3Svc s3iface.S3API, config SchismConfig, pubKey ssh.PublicKey, ttl time.Duration) (*ChallengeS3Object, error) {
+	rawNonce := make([]byte, challengeNonceBytes)
+	if _, err := rand.Read(rawNonce); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	challenge := &ChallengeS3Object{
+		Nonce:          base64.RawURLEncoding.EncodeToString(rawNonce),
+		KeyFingerprint: ssh.FingerprintSHA256(pubKey),
+		IssuedOn:       now,
+		ExpiresAt:      now.Add(ttl),
+	}
+	if _, err := SaveS3ObjectIfMatch(s3Svc, config, challenge, ""); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// ConsumeChallenge verifies the SSHSIG signature over nonce and then marks the
+// challenge used with a conditional write, so each nonce backs one certificate
+func ConsumeChallenge(s3Svc s3iface.S3API, config SchismConfig, nonce string, pubKey ssh.PublicKey, armoredSig []byte) error {
+	if nonce == "" {
+		return fmt.Errorf("a proof of possession challenge is required")
+	}
+	// the nonce ends up in an object key, only accept the exact form IssueChallenge hands out
+	rawNonce, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(rawNonce) != challengeNonceBytes || base64.RawURLEncoding.EncodeToString(rawNonce) != nonce {
+		return ErrChallengeMalformed
+	}
+	challenge := &ChallengeS3Object{Nonce: nonce}
+	etag, err := LoadS3Object(s3Svc, config, challenge)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if challenge.ConsumedOn != nil {
+		return ErrChallengeConsumed
+	}
+	if !now.Before(challenge.ExpiresAt) {
+		return ErrChallengeExpired
+	}
+	if challenge.KeyFingerprint != ssh.FingerprintSHA256(pubKey) {
+		return fmt.Errorf("challenge was issued for %s, not %s", challenge.KeyFingerprint, ssh.FingerprintSHA256(pubKey))
+	}
+	err = schismCrypt.VerifySSHSignature(pubKey, schismCrypt.ProofOfPossessionNamespace, []byte(nonce), armoredSig)
+	if err != nil {
+		return err
+	}
+	challenge.ConsumedOn = &now
+	_, err = SaveS3ObjectIfMatch(s3Svc, config, challenge, etag)
+	if errors.Is(err, ErrS3PreconditionFailed) {
+		return ErrChallengeConsumed
+	}
+	return err
+}
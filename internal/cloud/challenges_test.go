@@ -0,0 +1,173 @@
+package cloud
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	schismCrypt "code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+// testSSHSig builds the same armored blob as `ssh-keygen -Y sign -n <namespace>`
+func testSSHSig(t *testing.T, signer ssh.Signer, namespace string, message []byte) []byte {
+	t.Helper()
+	messageHash := sha512.Sum512(message)
+	signedData := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      []byte
+		HashAlgorithm string
+		Hash          []byte
+	}{namespace, nil, "sha512", messageHash[:]})...)
+	signature, err := signer.Sign(rand.Reader, signedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      []byte
+		HashAlgorithm string
+		Signature     []byte
+	}{1, signer.PublicKey().Marshal(), namespace, nil, "sha512", ssh.Marshal(signature)})...)
+	return pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob})
+}
+
+func testSSHSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestConsumeChallenge(t *testing.T) {
+	config := SchismConfig{CertsS3Bucket: "test-bucket", CertsS3Prefix: "test/"}
+	signer := testSSHSigner(t)
+	otherSigner := testSSHSigner(t)
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		sign      func(nonce string) []byte
+		replay    bool
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "valid signature",
+			ttl:  time.Minute,
+			sign: func(nonce string) []byte {
+				return testSSHSig(t, signer, schismCrypt.ProofOfPossessionNamespace, []byte(nonce))
+			},
+		},
+		{
+			name: "replayed signature",
+			ttl:  time.Minute,
+			sign: func(nonce string) []byte {
+				return testSSHSig(t, signer, schismCrypt.ProofOfPossessionNamespace, []byte(nonce))
+			},
+			replay:    true,
+			wantErr:   true,
+			wantErrIs: ErrChallengeConsumed,
+		},
+		{
+			name: "expired challenge",
+			ttl:  -time.Second,
+			sign: func(nonce string) []byte {
+				return testSSHSig(t, signer, schismCrypt.ProofOfPossessionNamespace, []byte(nonce))
+			},
+			wantErr:   true,
+			wantErrIs: ErrChallengeExpired,
+		},
+		{
+			name: "signed by another key",
+			ttl:  time.Minute,
+			sign: func(nonce string) []byte {
+				return testSSHSig(t, otherSigner, schismCrypt.ProofOfPossessionNamespace, []byte(nonce))
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed in another namespace",
+			ttl:  time.Minute,
+			sign: func(nonce string) []byte {
+				return testSSHSig(t, signer, "file", []byte(nonce))
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3Svc := newFakeS3Client()
+			challenge, err := IssueChallenge(s3Svc, config, signer.PublicKey(), tt.ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig := tt.sign(challenge.Nonce)
+			if tt.replay {
+				if err := ConsumeChallenge(s3Svc, config, challenge.Nonce, signer.PublicKey(), sig); err != nil {
+					t.Fatalf("first ConsumeChallenge() error = %v", err)
+				}
+			}
+			err = ConsumeChallenge(s3Svc, config, challenge.Nonce, signer.PublicKey(), sig)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ConsumeChallenge() error = %v, wantErr %v", err, tt.wantErr)
+			} else if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("ConsumeChallenge() error = %v, want %v", err, tt.wantErrIs)
+			}
+		})
+	}
+}
+
+func TestConsumeChallenge_UnknownNonce(t *testing.T) {
+	config := SchismConfig{CertsS3Bucket: "test-bucket"}
+	signer := testSSHSigner(t)
+	nonce := base64.RawURLEncoding.EncodeToString(make([]byte, challengeNonceBytes))
+	sig := testSSHSig(t, signer, schismCrypt.ProofOfPossessionNamespace, []byte(nonce))
+	if err := ConsumeChallenge(newFakeS3Client(), config, nonce, signer.PublicKey(), sig); !IsS3NotFound(err) {
+		t.Errorf("ConsumeChallenge() error = %v, want not found", err)
+	}
+	if err := ConsumeChallenge(newFakeS3Client(), config, "", signer.PublicKey(), sig); err == nil {
+		t.Errorf("ConsumeChallenge() without a nonce succeeded")
+	}
+}
+
+func TestConsumeChallenge_MalformedNonce(t *testing.T) {
+	config := SchismConfig{CertsS3Bucket: "test-bucket"}
+	signer := testSSHSigner(t)
+	valid := base64.RawURLEncoding.EncodeToString(make([]byte, challengeNonceBytes))
+	tests := []struct {
+		name  string
+		nonce string
+	}{
+		{name: "path traversal", nonce: "../Signed-Certs/user:abc"},
+		{name: "too short", nonce: valid[:42]},
+		{name: "too long", nonce: valid + "A"},
+		{name: "standard base64 alphabet", nonce: "/" + valid[1:]},
+		{name: "padded", nonce: valid[:42] + "="},
+		{name: "embedded newline", nonce: valid[:21] + "\n" + valid[22:]},
+		{name: "non canonical trailing bits", nonce: valid[:42] + "B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3Svc := newFakeS3Client()
+			sig := testSSHSig(t, signer, schismCrypt.ProofOfPossessionNamespace, []byte(tt.nonce))
+			if err := ConsumeChallenge(s3Svc, config, tt.nonce, signer.PublicKey(), sig); !errors.Is(err, ErrChallengeMalformed) {
+				t.Errorf("ConsumeChallenge() error = %v, want %v", err, ErrChallengeMalformed)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package cloud
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+const (
+	documentSourceSSM = "ssm:"
+	documentSourceS3  = "s3://"
+)
+
+// LoadDocument fetches a raw policy document from either "ssm:<parameter>"
+// or "s3://<bucket>/<key>"
+func LoadDocument(ssmSvc ssmiface.SSMAPI, s3Svc s3iface.S3API, source string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(source, documentSourceSSM):
+		ssmOutput, err := ssmSvc.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(strings.TrimPrefix(source, documentSourceSSM)),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []byte(aws.StringValue(ssmOutput.Parameter.Value)), nil
+	case strings.HasPrefix(source, documentSourceS3):
+		bucket, key, found := strings.Cut(strings.TrimPrefix(source, documentSourceS3), "/")
+		if !found || bucket == "" || key == "" {
+			return nil, fmt.Errorf("malformed s3 document source: %s", source)
+		}
+		getObjectOutput, err := s3Svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer getObjectOutput.Body.Close()
+		return io.ReadAll(getObjectOutput.Body)
+	default:
+		return nil, fmt.Errorf("unsupported document source: %s", source)
+	}
+}
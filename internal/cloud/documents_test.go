@@ -0,0 +1,58 @@
+package cloud
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadDocument(t *testing.T) {
+	ssmSvc := newFakeSSMClient()
+	ssmSvc.params["schism-principal-rules"] = "rules: []"
+	s3Svc := newFakeS3Client()
+	s3Svc.objects["policy/principal-rules.json"] = []byte(`{"rules":[]}`)
+
+	tests := []struct {
+		name    string
+		source  string
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:   "ssm parameter",
+			source: "ssm:schism-principal-rules",
+			want:   []byte("rules: []"),
+		},
+		{
+			name:   "s3 object",
+			source: "s3://schism-policy/policy/principal-rules.json",
+			want:   []byte(`{"rules":[]}`),
+		},
+		{
+			name:    "missing ssm parameter",
+			source:  "ssm:nope",
+			wantErr: true,
+		},
+		{
+			name:    "s3 source without a key",
+			source:  "s3://schism-policy",
+			wantErr: true,
+		},
+		{
+			name:    "unknown scheme",
+			source:  "https://example.com/rules.yaml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadDocument(ssmSvc, s3Svc, tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadDocument() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadDocument() got = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
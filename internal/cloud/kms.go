@@ -0,0 +1,181 @@
+package cloud
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"golang.org/x/crypto/ssh"
+
+	schismCrypt "code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+type kmsSigningAlgo struct {
+	hash      crypto.Hash
+	kmsAlgo   string
+	sshFormat string
+}
+
+var kmsSigningAlgos = map[string]kmsSigningAlgo{
+	ssh.KeyAlgoECDSA256: {crypto.SHA256, kms.SigningAlgorithmSpecEcdsaSha256, ssh.KeyAlgoECDSA256},
+	ssh.KeyAlgoECDSA384: {crypto.SHA384, kms.SigningAlgorithmSpecEcdsaSha384, ssh.KeyAlgoECDSA384},
+	ssh.KeyAlgoRSA:      {crypto.SHA512, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha512, ssh.KeyAlgoRSASHA512},
+}
+
+// KMSSigner is an ssh.Signer whose private key never leaves AWS KMS
+type KMSSigner struct {
+	kmsSvc    kmsiface.KMSAPI
+	keyId     string
+	publicKey ssh.PublicKey
+	algo      kmsSigningAlgo
+}
+
+func KMSClient(region string) kmsiface.KMSAPI {
+	return kms.New(session.Must(session.NewSession(&aws.Config{Region: aws.String(region)})))
+}
+
+func NewKMSSigner(kmsSvc kmsiface.KMSAPI, keyId string) (*KMSSigner, error) {
+	pubKeyOutput, err := kmsSvc.GetPublicKey(&kms.GetPublicKeyInput{KeyId: aws.String(keyId)})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(pubKeyOutput.KeyUsage) != kms.KeyUsageTypeSignVerify {
+		return nil, fmt.Errorf("kms key %s is not a signing key", keyId)
+	}
+	rawPubKey, err := x509.ParsePKIXPublicKey(pubKeyOutput.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ssh.NewPublicKey(rawPubKey)
+	if err != nil {
+		return nil, err
+	}
+	algo, ok := kmsSigningAlgos[publicKey.Type()]
+	if !ok {
+		return nil, fmt.Errorf("kms key %s has an unsupported key spec: %s", keyId, aws.StringValue(pubKeyOutput.KeySpec))
+	}
+	return &KMSSigner{kmsSvc: kmsSvc, keyId: keyId, publicKey: publicKey, algo: algo}, nil
+}
+
+func (s *KMSSigner) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+func (s *KMSSigner) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	digest := s.algo.hash.New()
+	digest.Write(data)
+	signOutput, err := s.kmsSvc.Sign(&kms.SignInput{
+		KeyId:            aws.String(s.keyId),
+		Message:          digest.Sum(nil),
+		MessageType:      aws.String(kms.MessageTypeDigest),
+		SigningAlgorithm: aws.String(s.algo.kmsAlgo),
+	})
+	if err != nil {
+		return nil, err
+	}
+	blob := signOutput.Signature
+	if s.publicKey.Type() != ssh.KeyAlgoRSA {
+		// KMS returns ASN.1 DER, ssh wants the two mpints back to back
+		var ecdsaSig struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(signOutput.Signature, &ecdsaSig); err != nil {
+			return nil, err
+		}
+		blob = ssh.Marshal(ecdsaSig)
+	}
+	return &ssh.Signature{Format: s.algo.sshFormat, Blob: blob}, nil
+}
+
+func LoadCaKeyRingFromKMS(kmsSvc kmsiface.KMSAPI, keyId string) (*schismCrypt.CaKeyRing, error) {
+	signer, err := NewKMSSigner(kmsSvc, keyId)
+	if err != nil {
+		return nil, err
+	}
+	caPair := schismCrypt.NewSignerCaPair(signer)
+	if err := caPair.Verify(); err != nil {
+		return nil, fmt.Errorf("KMS CA '%s' failed verification: %w", keyId, err)
+	}
+	return &schismCrypt.CaKeyRing{Current: caPair}, nil
+}
+
+// SealSchemeKMSDataKey encrypts under a fresh AES-256 data key from KMS, only
+// the KMS encrypted copy of the data key is kept
+const SealSchemeKMSDataKey = "kms-data-key-aes256-gcm"
+
+type KMSSealedBox struct {
+	Scheme           string `json:"scheme"`
+	KeyId            string `json:"kms_key_id"`
+	EncryptedDataKey []byte `json:"encrypted_data_key"`
+	Nonce            []byte `json:"nonce"`
+}
+
+func SealWithKMS(kmsSvc kmsiface.KMSAPI, keyId string, plaintext []byte) (*KMSSealedBox, []byte, error) {
+	dataKey, err := kmsSvc.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(keyId),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zeroBytes(dataKey.Plaintext)
+	aead, err := dataKeyAEAD(dataKey.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	box := &KMSSealedBox{
+		Scheme:           SealSchemeKMSDataKey,
+		KeyId:            aws.StringValue(dataKey.KeyId),
+		EncryptedDataKey: dataKey.CiphertextBlob,
+		Nonce:            nonce,
+	}
+	return box, aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// Open is the client half of SealWithKMS, it needs kms:Decrypt on the box's key
+func (box *KMSSealedBox) Open(kmsSvc kmsiface.KMSAPI, ciphertext []byte) ([]byte, error) {
+	if box.Scheme != SealSchemeKMSDataKey {
+		return nil, fmt.Errorf("unsupported sealing scheme: %s", box.Scheme)
+	}
+	dataKey, err := kmsSvc.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(box.KeyId),
+		CiphertextBlob: box.EncryptedDataKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(dataKey.Plaintext)
+	aead, err := dataKeyAEAD(dataKey.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, box.Nonce, ciphertext, nil)
+}
+
+func dataKeyAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,197 @@
+package cloud
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+	schismCrypt "code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+const revocationAttempts = 5
+
+// ErrKRLSuperseded means a newer KRL was published first, nothing needs doing
+var ErrKRLSuperseded = errors.New("a newer KRL is already published")
+
+type Revocation struct {
+	Serial uint64 `json:"serial,omitempty"`
+	// EarlierSerials were issued to the same lookup key before Serial and
+	// hadn't expired when it was revoked
+	EarlierSerials []uint64 `json:"earlier_serials,omitempty"`
+
+	KeyID     string    `json:"key_id,omitempty"`
+	PublicKey string    `json:"public_key,omitempty"`
+	LookupKey string    `json:"lookup_key,omitempty"`
+	Reason    string    `json:"reason"`
+	RevokedOn time.Time `json:"revoked_on"`
+}
+
+type RevocationListS3Object struct {
+	CertificateType protocol.CertificateType `json:"certificate_type"`
+	Version         uint64                   `json:"version"`
+	Revocations     []Revocation             `json:"revocations"`
+}
+
+func (l *RevocationListS3Object) ObjectKey(prefix string) string {
+	return fmt.Sprintf("%sRevocations/%s.json", prefix, l.CertificateType)
+}
+
+// KRLObjectKey sits right next to the matching CAPublicKeyS3Object
+func KRLObjectKey(prefix string, certType protocol.CertificateType) string {
+	caObjectKey := (&protocol.CAPublicKeyS3Object{CertificateType: certType}).ObjectKey(prefix)
+	return strings.TrimSuffix(caObjectKey, ".json") + ".krl"
+}
+
+func (l *RevocationListS3Object) KRL(caKeys []ssh.PublicKey) (*schismCrypt.KRL, error) {
+	var serials []uint64
+	var keyIds []string
+	var revokedKeys []ssh.PublicKey
+	for _, revocation := range l.Revocations {
+		if revocation.Serial != 0 {
+			serials = append(serials, revocation.Serial)
+		}
+		serials = append(serials, revocation.EarlierSerials...)
+		if revocation.KeyID != "" {
+			keyIds = append(keyIds, revocation.KeyID)
+		}
+		if revocation.PublicKey != "" {
+			pubKey, err := schismCrypt.LazyParseAuthorizedKey([]byte(revocation.PublicKey))
+			if err != nil {
+				return nil, fmt.Errorf("revoked public key is unreadable: %w", err)
+			}
+			revokedKeys = append(revokedKeys, pubKey)
+		}
+	}
+	krl := &schismCrypt.KRL{
+		Version:     l.Version,
+		GeneratedAt: time.Now(),
+		Comment:     fmt.Sprintf("schism %s CA revocations", l.CertificateType),
+		RevokedKeys: revokedKeys,
+	}
+	for _, caKey := range caKeys {
+		krl.Certificates = append(krl.Certificates, schismCrypt.KRLCertificates{
+			CA:      caKey,
+			Serials: serials,
+			KeyIDs:  keyIds,
+		})
+	}
+	return krl, nil
+}
+
+// Revokes finds the first revocation that covers cert, if any
+func (l *RevocationListS3Object) Revokes(cert *ssh.Certificate) *Revocation {
+	for i, revocation := range l.Revocations {
+		if revocation.Serial != 0 && revocation.Serial == cert.Serial {
+			return &l.Revocations[i]
+		}
+		for _, serial := range revocation.EarlierSerials {
+			if serial == cert.Serial {
+				return &l.Revocations[i]
+			}
+		}
+		if revocation.KeyID != "" && revocation.KeyID == cert.KeyId {
+			return &l.Revocations[i]
+		}
+		if revocation.PublicKey == "" {
+			continue
+		}
+		if pubKey, err := schismCrypt.LazyParseAuthorizedKey([]byte(revocation.PublicKey)); err == nil &&
+			bytes.Equal(pubKey.Marshal(), cert.Key.Marshal()) {
+			return &l.Revocations[i]
+		}
+	}
+	return nil
+}
+
+func LoadRevocationList(s3Svc s3iface.S3API, config SchismConfig, certType protocol.CertificateType) (*RevocationListS3Object, string, error) {
+	list := &RevocationListS3Object{CertificateType: certType}
+	etag, err := LoadS3Object(s3Svc, config, list)
+	if IsS3NotFound(err) {
+		return &RevocationListS3Object{CertificateType: certType}, "", nil
+	}
+	return list, etag, err
+}
+
+// PublishKRL never replaces a KRL built from a newer revocation list, an
+// invocation that lost a race would otherwise un-revoke certificates. The
+// same version is republished since the CA keys may have rotated.
+func PublishKRL(s3Svc s3iface.S3API, config SchismConfig, list *RevocationListS3Object, caKeys []ssh.PublicKey) (string, error) {
+	krl, err := list.KRL(caKeys)
+	if err != nil {
+		return "", err
+	}
+	objectKey := KRLObjectKey(config.CertsS3Prefix, list.CertificateType)
+	for attempt := 0; attempt < revocationAttempts; attempt++ {
+		published, etag, err := LoadS3Bytes(s3Svc, config, objectKey)
+		if err != nil && !IsS3NotFound(err) {
+			return "", err
+		}
+		if err == nil {
+			publishedVersion, err := schismCrypt.ParseKRLVersion(published)
+			if err != nil {
+				return "", fmt.Errorf("published KRL '%s' is unreadable: %w", objectKey, err)
+			}
+			if publishedVersion > list.Version {
+				return "", fmt.Errorf("%w: version %d is already published, not replacing it with %d",
+					ErrKRLSuperseded, publishedVersion, list.Version)
+			}
+		}
+		_, err = SaveS3BytesIfMatch(s3Svc, config, objectKey, krl.Marshal(), etag)
+		if errors.Is(err, ErrS3PreconditionFailed) {
+			continue
+		}
+		return objectKey, err
+	}
+	return "", fmt.Errorf("unable to publish KRL after %d attempts", revocationAttempts)
+}
+
+// PublishMissingKRL only writes a KRL where there is none, sshd refuses every
+// key when its RevokedKeys file can't be read. Any KRL already there is left
+// alone and reported as ErrKRLSuperseded.
+func PublishMissingKRL(s3Svc s3iface.S3API, config SchismConfig, list *RevocationListS3Object, caKeys []ssh.PublicKey) (string, error) {
+	krl, err := list.KRL(caKeys)
+	if err != nil {
+		return "", err
+	}
+	objectKey := KRLObjectKey(config.CertsS3Prefix, list.CertificateType)
+	_, err = SaveS3BytesIfMatch(s3Svc, config, objectKey, krl.Marshal(), "")
+	if errors.Is(err, ErrS3PreconditionFailed) {
+		return "", fmt.Errorf("%w: '%s' already exists", ErrKRLSuperseded, objectKey)
+	} else if err != nil {
+		return "", err
+	}
+	return objectKey, nil
+}
+
+// RecordRevocation appends to the certificate type's revocation list and
+// republishes its KRL, retrying if another invocation updated the list first.
+func RecordRevocation(s3Svc s3iface.S3API, config SchismConfig, certType protocol.CertificateType, caKeys []ssh.PublicKey, revocation Revocation) (*RevocationListS3Object, error) {
+	if revocation.Serial == 0 && revocation.KeyID == "" && revocation.PublicKey == "" {
+		return nil, errors.New("revocation needs a serial, key id or public key")
+	}
+	for attempt := 0; attempt < revocationAttempts; attempt++ {
+		list, etag, err := LoadRevocationList(s3Svc, config, certType)
+		if err != nil {
+			return nil, err
+		}
+		list.Version++
+		list.Revocations = append(list.Revocations, revocation)
+		_, err = SaveS3ObjectIfMatch(s3Svc, config, list, etag)
+		if errors.Is(err, ErrS3PreconditionFailed) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if _, err = PublishKRL(s3Svc, config, list, caKeys); err != nil && !errors.Is(err, ErrKRLSuperseded) {
+			return nil, err
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("unable to record revocation after %d attempts", revocationAttempts)
+}
@@ -0,0 +1,191 @@
+package cloud
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestKRLObjectKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		certType protocol.CertificateType
+		want     string
+	}{
+		{name: "no prefix", certType: protocol.UserCertificate, want: "CA-Pubkeys/user.krl"},
+		{name: "with prefix", prefix: "test/", certType: protocol.HostCertificate, want: "test/CA-Pubkeys/host.krl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KRLObjectKey(tt.prefix, tt.certType); got != tt.want {
+				t.Errorf("KRLObjectKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordRevocation(t *testing.T) {
+	config := SchismConfig{CertsS3Bucket: "schism-test", CertsS3Prefix: "test/"}
+	ca, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	caKey, _, _, _, _ := ssh.ParseAuthorizedKey(ca.AuthorizedKey)
+	caKeys := []ssh.PublicKey{caKey}
+
+	tests := []struct {
+		name        string
+		revocation  Revocation
+		wantVersion uint64
+		wantErr     bool
+	}{
+		{
+			name:        "revoke by serial",
+			revocation:  Revocation{Serial: 12, Reason: "laptop stolen", RevokedOn: time.Now()},
+			wantVersion: 1,
+		},
+		{
+			name:        "revoke by key id",
+			revocation:  Revocation{KeyID: "alice@example.com", Reason: "left the company", RevokedOn: time.Now()},
+			wantVersion: 2,
+		},
+		{
+			name:        "revoke by public key",
+			revocation:  Revocation{PublicKey: string(ca.AuthorizedKey), Reason: "key leaked", RevokedOn: time.Now()},
+			wantVersion: 3,
+		},
+		{
+			name:       "nothing to revoke",
+			revocation: Revocation{Reason: "oops"},
+			wantErr:    true,
+		},
+		{
+			name:       "unreadable public key",
+			revocation: Revocation{PublicKey: "not-a-key", Reason: "oops"},
+			wantErr:    true,
+		},
+	}
+	s3Svc := newFakeS3Client()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RecordRevocation(s3Svc, config, protocol.UserCertificate, caKeys, tt.revocation)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RecordRevocation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			} else if err != nil {
+				return
+			}
+			if got.Version != tt.wantVersion || len(got.Revocations) != int(tt.wantVersion) {
+				t.Errorf("RecordRevocation() got version %d with %d revocations, want %d",
+					got.Version, len(got.Revocations), tt.wantVersion)
+			}
+			krl := s3Svc.objects[KRLObjectKey(config.CertsS3Prefix, protocol.UserCertificate)]
+			if !bytes.HasPrefix(krl, []byte("SSHKRL\n\x00")) {
+				t.Errorf("RecordRevocation() published KRL = %q, wanted an OpenSSH KRL", krl)
+			}
+		})
+	}
+}
+
+func TestPublishKRL(t *testing.T) {
+	config := SchismConfig{CertsS3Bucket: "schism-test"}
+	ca, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	caKey, _, _, _, _ := ssh.ParseAuthorizedKey(ca.AuthorizedKey)
+	s3Svc := newFakeS3Client()
+	objectKey := KRLObjectKey(config.CertsS3Prefix, protocol.HostCertificate)
+
+	tests := []struct {
+		name        string
+		version     uint64
+		wantVersion uint64
+		wantErr     error
+	}{
+		{name: "first publish", version: 2, wantVersion: 2},
+		{name: "same version", version: 2, wantVersion: 2},
+		{name: "newer version", version: 3, wantVersion: 3},
+		{name: "stale version", version: 2, wantVersion: 3, wantErr: ErrKRLSuperseded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := &RevocationListS3Object{CertificateType: protocol.HostCertificate, Version: tt.version}
+			_, err := PublishKRL(s3Svc, config, list, []ssh.PublicKey{caKey})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("PublishKRL() error = %v, want %v", err, tt.wantErr)
+			}
+			got, _ := crypto.ParseKRLVersion(s3Svc.objects[objectKey])
+			if got != tt.wantVersion {
+				t.Errorf("PublishKRL() left version %d published, want %d", got, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestPublishMissingKRL(t *testing.T) {
+	config := SchismConfig{CertsS3Bucket: "schism-test"}
+	ca, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	caKey, _, _, _, _ := ssh.ParseAuthorizedKey(ca.AuthorizedKey)
+	s3Svc := newFakeS3Client()
+	objectKey := KRLObjectKey(config.CertsS3Prefix, protocol.UserCertificate)
+
+	tests := []struct {
+		name        string
+		version     uint64
+		wantVersion uint64
+		wantErr     error
+	}{
+		{name: "missing", version: 0, wantVersion: 0},
+		{name: "published since", version: 4, wantVersion: 0, wantErr: ErrKRLSuperseded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := &RevocationListS3Object{CertificateType: protocol.UserCertificate, Version: tt.version}
+			_, err := PublishMissingKRL(s3Svc, config, list, []ssh.PublicKey{caKey})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("PublishMissingKRL() error = %v, want %v", err, tt.wantErr)
+			}
+			got, err := crypto.ParseKRLVersion(s3Svc.objects[objectKey])
+			if err != nil || got != tt.wantVersion {
+				t.Errorf("PublishMissingKRL() left version %d (%v) published, want %d", got, err, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestRevocationListS3Object_Revokes(t *testing.T) {
+	ca, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	caSigner, _ := ca.Signer()
+	userKey, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	otherKey, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	cert, err := crypto.Sign(&crypto.SigningReq{
+		PublicKey: userKey.AuthorizedKey, CertType: ssh.UserCert, Identity: "alice",
+		Principals: []string{"alice"}, TTL: time.Hour, Serials: crypto.NewMemorySerialAllocator(7),
+	}, caSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		revocation Revocation
+		want       bool
+	}{
+		{name: "by serial", revocation: Revocation{Serial: 7}, want: true},
+		{name: "by key id", revocation: Revocation{KeyID: "alice"}, want: true},
+		{name: "by public key", revocation: Revocation{PublicKey: strings.TrimSpace(string(userKey.AuthorizedKey)) + " alice@laptop"}, want: true},
+		{name: "by earlier serial", revocation: Revocation{Serial: 9, EarlierSerials: []uint64{6, 7}}, want: true},
+		{name: "another serial", revocation: Revocation{Serial: 8}},
+		{name: "another public key", revocation: Revocation{PublicKey: string(otherKey.AuthorizedKey)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := &RevocationListS3Object{Revocations: []Revocation{tt.revocation}}
+			if got := list.Revokes(cert); (got != nil) != tt.want {
+				t.Errorf("Revokes() = %v, want revoked %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package cloud
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	schismCrypt "code.agarg.me/schism/lambda-function/internal/crypto"
+	"code.agarg.me/schism/lambda-function/internal/policy"
+)
+
+const DefaultCANamespace = ""
+
+var caNamespaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// CANamespace overrides parts of the base SchismConfig for one independent set
+// of CAs. Unset SSM and S3 prefixes are derived from the name so namespaces
+// never share key material or published objects, KMS key ids are never
+// inherited and must be set by every namespace on the kms backend.
+type CANamespace struct {
+	Name string `json:"name" yaml:"name"`
+
+	CaParamPrefix  string `json:"ca_param_prefix,omitempty" yaml:"ca_param_prefix,omitempty"`
+	CaSsmKmsKeyId  string `json:"ca_ssm_kms_key_id,omitempty" yaml:"ca_ssm_kms_key_id,omitempty"`
+	CaBackend      string `json:"ca_backend,omitempty" yaml:"ca_backend,omitempty"`
+	KmsHostCaKeyId string `json:"kms_host_ca_key_id,omitempty" yaml:"kms_host_ca_key_id,omitempty"`
+	KmsUserCaKeyId string `json:"kms_user_ca_key_id,omitempty" yaml:"kms_user_ca_key_id,omitempty"`
+	CertsS3Bucket  string `json:"certs_s3_bucket,omitempty" yaml:"certs_s3_bucket,omitempty"`
+	CertsS3Prefix  string `json:"certs_s3_prefix,omitempty" yaml:"certs_s3_prefix,omitempty"`
+
+	HostCertsAuthDomain       string   `json:"host_certs_auth_domain,omitempty" yaml:"host_certs_auth_domain,omitempty"`
+	HostAllowedDomains        []string `json:"host_allowed_domains,omitempty" yaml:"host_allowed_domains,omitempty"`
+	HostAllowedCIDRs          []string `json:"host_allowed_cidrs,omitempty" yaml:"host_allowed_cidrs,omitempty"`
+	HostAllowedNames          []string `json:"host_allowed_names,omitempty" yaml:"host_allowed_names,omitempty"`
+	KeyAllowedAlgorithms      []string `json:"key_allowed_algorithms,omitempty" yaml:"key_allowed_algorithms,omitempty"`
+	KeyMinRSABits             *int     `json:"key_min_rsa_bits,omitempty" yaml:"key_min_rsa_bits,omitempty"`
+	KeyAllowedECDSACurves     []string `json:"key_allowed_ecdsa_curves,omitempty" yaml:"key_allowed_ecdsa_curves,omitempty"`
+	UserKeyRequireSecurityKey *bool    `json:"user_key_require_security_key,omitempty" yaml:"user_key_require_security_key,omitempty"`
+	RequireProofOfPossession  *bool    `json:"require_proof_of_possession,omitempty" yaml:"require_proof_of_possession,omitempty"`
+	PrincipalRulesSource      string   `json:"principal_rules_source,omitempty" yaml:"principal_rules_source,omitempty"`
+	SigningProfilesSource     string   `json:"signing_profiles_source,omitempty" yaml:"signing_profiles_source,omitempty"`
+	KeyIDTemplate             string   `json:"key_id_template,omitempty" yaml:"key_id_template,omitempty"`
+
+	HostCertDefaultTTL policy.Duration            `json:"host_cert_default_ttl,omitempty" yaml:"host_cert_default_ttl,omitempty"`
+	HostCertMaxTTL     policy.Duration            `json:"host_cert_max_ttl,omitempty" yaml:"host_cert_max_ttl,omitempty"`
+	UserCertDefaultTTL policy.Duration            `json:"user_cert_default_ttl,omitempty" yaml:"user_cert_default_ttl,omitempty"`
+	UserCertMaxTTL     policy.Duration            `json:"user_cert_max_ttl,omitempty" yaml:"user_cert_max_ttl,omitempty"`
+	PrincipalMaxTTLs   map[string]policy.Duration `json:"principal_max_ttls,omitempty" yaml:"principal_max_ttls,omitempty"`
+	TTLMode            string                     `json:"ttl_mode,omitempty" yaml:"ttl_mode,omitempty"`
+}
+
+func (namespace *CANamespace) validate() error {
+	if !caNamespaceName.MatchString(namespace.Name) {
+		return fmt.Errorf("name must match %s", caNamespaceName)
+	}
+	for field, ttl := range map[string]policy.Duration{
+		"host_cert_default_ttl": namespace.HostCertDefaultTTL,
+		"host_cert_max_ttl":     namespace.HostCertMaxTTL,
+		"user_cert_default_ttl": namespace.UserCertDefaultTTL,
+		"user_cert_max_ttl":     namespace.UserCertMaxTTL,
+	} {
+		if ttl < 0 {
+			return fmt.Errorf("%s must be positive", field)
+		}
+	}
+	for principal, ttl := range namespace.PrincipalMaxTTLs {
+		if ttl <= 0 {
+			return fmt.Errorf("principal_max_ttls: %s must be positive", principal)
+		}
+	}
+	if namespace.KeyMinRSABits != nil && *namespace.KeyMinRSABits <= 0 {
+		return fmt.Errorf("key_min_rsa_bits must be positive")
+	}
+	switch namespace.TTLMode {
+	case "", schismCrypt.TTLModeClamp, schismCrypt.TTLModeReject:
+	default:
+		return fmt.Errorf("ttl_mode must be %s or %s", schismCrypt.TTLModeClamp, schismCrypt.TTLModeReject)
+	}
+	return nil
+}
+
+type caNamespaceDocument struct {
+	Namespaces []*CANamespace `json:"namespaces" yaml:"namespaces"`
+}
+
+// ParseCANamespaces resolves every namespace in doc against base, the
+// DefaultCANamespace is always base itself
+func ParseCANamespaces(doc []byte, base SchismConfig) (map[string]SchismConfig, error) {
+	namespaceDoc := &caNamespaceDocument{}
+	if err := policy.UnmarshalDocument(doc, namespaceDoc); err != nil {
+		return nil, err
+	}
+	configs := map[string]SchismConfig{DefaultCANamespace: base}
+	paramPrefixes := map[string]string{base.CaParamPrefix: "the default namespace"}
+	s3Prefixes := map[string]string{base.CertsS3Bucket + "/" + base.CertsS3Prefix: "the default namespace"}
+	kmsKeyIds := map[string]string{}
+	if base.CaBackend == CaBackendKMS {
+		kmsKeyIds[base.KmsHostCaKeyId] = "the default namespace"
+		kmsKeyIds[base.KmsUserCaKeyId] = "the default namespace"
+	}
+	for i, namespace := range namespaceDoc.Namespaces {
+		if namespace == nil {
+			return nil, fmt.Errorf("CA namespace %d: name must match %s", i, caNamespaceName)
+		}
+		if err := namespace.validate(); err != nil {
+			return nil, fmt.Errorf("CA namespace %d (%s): %w", i, namespace.Name, err)
+		}
+		if _, exists := configs[namespace.Name]; exists {
+			return nil, fmt.Errorf("CA namespace %s: defined more than once", namespace.Name)
+		}
+		config := base.ForNamespace(namespace)
+		if other, taken := paramPrefixes[config.CaParamPrefix]; taken {
+			return nil, fmt.Errorf("CA namespace %s: ca_param_prefix is already used by %s", namespace.Name, other)
+		}
+		s3Location := config.CertsS3Bucket + "/" + config.CertsS3Prefix
+		if other, taken := s3Prefixes[s3Location]; taken {
+			return nil, fmt.Errorf("CA namespace %s: certs_s3_prefix is already used by %s", namespace.Name, other)
+		}
+		if config.CaBackend == CaBackendKMS {
+			if config.KmsHostCaKeyId == "" || config.KmsUserCaKeyId == "" {
+				return nil, fmt.Errorf("CA namespace %s: kms_host_ca_key_id and kms_user_ca_key_id are required with the %s backend", namespace.Name, CaBackendKMS)
+			}
+			if config.KmsHostCaKeyId == config.KmsUserCaKeyId {
+				return nil, fmt.Errorf("CA namespace %s: the host and user CAs can't share a KMS key", namespace.Name)
+			}
+			for _, keyId := range []string{config.KmsHostCaKeyId, config.KmsUserCaKeyId} {
+				if other, taken := kmsKeyIds[keyId]; taken {
+					return nil, fmt.Errorf("CA namespace %s: KMS key %s is already used by %s", namespace.Name, keyId, other)
+				}
+				kmsKeyIds[keyId] = namespace.Name
+			}
+		}
+		paramPrefixes[config.CaParamPrefix] = namespace.Name
+		s3Prefixes[s3Location] = namespace.Name
+		configs[namespace.Name] = config
+	}
+	return configs, nil
+}
+
+func (sc SchismConfig) ForNamespace(namespace *CANamespace) SchismConfig {
+	sc.CaParamPrefix = sc.CaParamPrefix + namespace.Name
+	sc.CertsS3Prefix = sc.CertsS3Prefix + namespace.Name + "/"
+	sc.KmsHostCaKeyId = ""
+	sc.KmsUserCaKeyId = ""
+	overrideString(&sc.CaParamPrefix, namespace.CaParamPrefix)
+	overrideString(&sc.CaSsmKmsKeyId, namespace.CaSsmKmsKeyId)
+	overrideString(&sc.CaBackend, namespace.CaBackend)
+	overrideString(&sc.KmsHostCaKeyId, namespace.KmsHostCaKeyId)
+	overrideString(&sc.KmsUserCaKeyId, namespace.KmsUserCaKeyId)
+	overrideString(&sc.CertsS3Bucket, namespace.CertsS3Bucket)
+	overrideString(&sc.CertsS3Prefix, namespace.CertsS3Prefix)
+	overrideString(&sc.HostCertsAuthDomain, namespace.HostCertsAuthDomain)
+	overrideList(&sc.HostAllowedDomains, namespace.HostAllowedDomains)
+	overrideList(&sc.HostAllowedCIDRs, namespace.HostAllowedCIDRs)
+	overrideList(&sc.HostAllowedNames, namespace.HostAllowedNames)
+	overrideList(&sc.KeyAllowedAlgorithms, namespace.KeyAllowedAlgorithms)
+	overrideInt(&sc.KeyMinRSABits, namespace.KeyMinRSABits)
+	overrideList(&sc.KeyAllowedECDSACurves, namespace.KeyAllowedECDSACurves)
+	overrideBool(&sc.UserKeyRequireSecurityKey, namespace.UserKeyRequireSecurityKey)
+	overrideBool(&sc.RequireProofOfPossession, namespace.RequireProofOfPossession)
+	overrideString(&sc.PrincipalRulesSource, namespace.PrincipalRulesSource)
+	overrideString(&sc.SigningProfilesSource, namespace.SigningProfilesSource)
+	overrideString(&sc.KeyIDTemplate, namespace.KeyIDTemplate)
+	overrideDuration(&sc.HostCertDefaultTTL, namespace.HostCertDefaultTTL)
+	overrideDuration(&sc.HostCertMaxTTL, namespace.HostCertMaxTTL)
+	overrideDuration(&sc.UserCertDefaultTTL, namespace.UserCertDefaultTTL)
+	overrideDuration(&sc.UserCertMaxTTL, namespace.UserCertMaxTTL)
+	if namespace.PrincipalMaxTTLs != nil {
+		sc.PrincipalMaxTTLs = map[string]time.Duration{}
+		for principal, ttl := range namespace.PrincipalMaxTTLs {
+			sc.PrincipalMaxTTLs[principal] = time.Duration(ttl)
+		}
+	}
+	overrideString(&sc.TTLMode, namespace.TTLMode)
+	return sc
+}
+
+func overrideString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+func overrideList(field *[]string, value []string) {
+	if value != nil {
+		*field = value
+	}
+}
+
+func overrideInt(field *int, value *int) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+func overrideDuration(field *time.Duration, value policy.Duration) {
+	if value != 0 {
+		*field = time.Duration(value)
+	}
+}
+
+func overrideBool(field *bool, value *bool) {
+	if value != nil {
+		*field = *value
+	}
+}
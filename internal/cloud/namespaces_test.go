@@ -0,0 +1,163 @@
+package cloud_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"code.agarg.me/schism/lambda-function/internal/cloud"
+)
+
+func TestParseCANamespaces(t *testing.T) {
+	base := cloud.SchismConfig{
+		CaParamPrefix:        "schism-",
+		CertsS3Bucket:        "schism-signed-certificates",
+		CertsS3Prefix:        "",
+		HostCertsAuthDomain:  "example.com",
+		KeyAllowedAlgorithms: []string{"ssh-ed25519", "ssh-rsa"},
+	}
+	prod := base
+	prod.CaParamPrefix = "schism-prod"
+	prod.CertsS3Prefix = "prod/"
+	partner := base
+	partner.CaParamPrefix = "partner-ca"
+	partner.CertsS3Bucket = "partner-certificates"
+	partner.CertsS3Prefix = "partner/"
+	partner.HostCertsAuthDomain = "partner.example.net"
+	partner.KeyAllowedAlgorithms = []string{"ssh-ed25519"}
+	partner.UserKeyRequireSecurityKey = true
+	partner.KeyMinRSABits = 4096
+	partner.KeyAllowedECDSACurves = []string{"nistp384"}
+	partner.UserCertMaxTTL = time.Hour
+	partner.PrincipalMaxTTLs = map[string]time.Duration{"root": 10 * time.Minute}
+	partner.TTLMode = "reject"
+
+	kmsBase := base
+	kmsBase.CaBackend = cloud.CaBackendKMS
+	kmsBase.KmsHostCaKeyId = "prod-host"
+	kmsBase.KmsUserCaKeyId = "prod-user"
+	staging := kmsBase
+	staging.CaParamPrefix = "schism-staging"
+	staging.CertsS3Prefix = "staging/"
+	staging.KmsHostCaKeyId = "staging-host"
+	staging.KmsUserCaKeyId = "staging-user"
+	ssmStaging := base
+	ssmStaging.CaParamPrefix = "schism-staging"
+	ssmStaging.CertsS3Prefix = "staging/"
+	ssmStaging.CaBackend = cloud.CaBackendSSM
+
+	tests := []struct {
+		name    string
+		base    *cloud.SchismConfig
+		doc     string
+		want    map[string]cloud.SchismConfig
+		wantErr bool
+	}{
+		{
+			name: "no namespaces is just the default",
+			doc:  "namespaces: []",
+			want: map[string]cloud.SchismConfig{cloud.DefaultCANamespace: base},
+		},
+		{
+			name: "derived and explicit settings",
+			doc: `
+namespaces:
+  - name: prod
+  - name: partner
+    ca_param_prefix: partner-ca
+    certs_s3_bucket: partner-certificates
+    host_certs_auth_domain: partner.example.net
+    key_allowed_algorithms: [ssh-ed25519]
+    user_key_require_security_key: true
+    key_min_rsa_bits: 4096
+    key_allowed_ecdsa_curves: [nistp384]
+    user_cert_max_ttl: 1h
+    principal_max_ttls: {root: 10m}
+    ttl_mode: reject
+`,
+			want: map[string]cloud.SchismConfig{cloud.DefaultCANamespace: base, "prod": prod, "partner": partner},
+		},
+		{
+			name:    "duplicate names",
+			doc:     `{"namespaces": [{"name": "prod"}, {"name": "prod", "ca_param_prefix": "other"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "bad name",
+			doc:     `{"namespaces": [{"name": "Prod/EU"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "shared ssm prefix",
+			doc:     `{"namespaces": [{"name": "prod"}, {"name": "staging", "ca_param_prefix": "schism-prod"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "shared s3 prefix",
+			doc:     `{"namespaces": [{"name": "staging", "certs_s3_prefix": ""}, {"name": "prod", "certs_s3_prefix": "staging/"}]}`,
+			wantErr: true,
+		},
+		{
+			name: "kms namespace with its own keys",
+			base: &kmsBase,
+			doc:  `{"namespaces": [{"name": "staging", "kms_host_ca_key_id": "staging-host", "kms_user_ca_key_id": "staging-user"}]}`,
+			want: map[string]cloud.SchismConfig{cloud.DefaultCANamespace: kmsBase, "staging": staging},
+		},
+		{
+			name: "kms ids are not inherited",
+			base: &kmsBase,
+			doc:  `{"namespaces": [{"name": "staging", "ca_backend": "ssm"}]}`,
+			want: map[string]cloud.SchismConfig{cloud.DefaultCANamespace: kmsBase, "staging": ssmStaging},
+		},
+		{
+			name:    "kms namespace without keys",
+			base:    &kmsBase,
+			doc:     `{"namespaces":[{"name":"staging"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "kms key shared with the default namespace",
+			base:    &kmsBase,
+			doc:     `{"namespaces": [{"name": "staging", "kms_host_ca_key_id": "prod-host", "kms_user_ca_key_id": "staging-user"}]}`,
+			wantErr: true,
+		},
+		{
+			name: "kms key shared between namespaces",
+			doc: `{"namespaces": [
+				{"name": "staging", "ca_backend": "kms", "kms_host_ca_key_id": "shared", "kms_user_ca_key_id": "staging-user"},
+				{"name": "partner", "ca_backend": "kms", "kms_host_ca_key_id": "partner-host", "kms_user_ca_key_id": "shared"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "kms key shared by host and user",
+			doc:     `{"namespaces": [{"name": "staging", "ca_backend": "kms", "kms_host_ca_key_id": "one", "kms_user_ca_key_id": "one"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "negative ttl",
+			doc:     `{"namespaces": [{"name": "staging", "user_cert_max_ttl": "-1h"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown ttl mode",
+			doc:     `{"namespaces": [{"name": "staging", "ttl_mode": "truncate"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespaceBase := base
+			if tt.base != nil {
+				namespaceBase = *tt.base
+			}
+			got, err := cloud.ParseCANamespaces([]byte(tt.doc), namespaceBase)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCANamespaces() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCANamespaces() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package cloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	schismCrypt "code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+// the CaKeyRing slots map onto Secrets Manager's own rotation stages
+const (
+	secretStageCurrent  = "AWSCURRENT"
+	secretStagePending  = "AWSPENDING"
+	secretStagePrevious = "AWSPREVIOUS"
+)
+
+func SecretsManagerClient(region string) secretsmanageriface.SecretsManagerAPI {
+	return secretsmanager.New(session.Must(session.NewSession(&aws.Config{Region: aws.String(region)})))
+}
+
+// SecretsManagerCAStore keeps each CA as a secret named by CaParamName, every
+// key of the ring is a version of it labelled with its rotation stage.
+type SecretsManagerCAStore struct {
+	SecretsSvc secretsmanageriface.SecretsManagerAPI
+	Config     SchismConfig
+}
+
+type secretVersion struct {
+	id     string
+	caPair *schismCrypt.EncodedCaPair
+}
+
+func IsSecretNotFound(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException
+}
+
+func (s *SecretsManagerCAStore) LoadKeyRing(certType protocol.CertificateType) (*schismCrypt.CaKeyRing, error) {
+	stages, err := s.loadStages(s.Config.CaParamName(certType))
+	if err != nil {
+		return nil, err
+	}
+	if stages[secretStageCurrent] == nil {
+		return nil, notFoundError{fmt.Errorf("secret '%s' has no %s version", s.Config.CaParamName(certType), secretStageCurrent)}
+	}
+	ring := &schismCrypt.CaKeyRing{Current: stages[secretStageCurrent].caPair}
+	if pending := stages[secretStagePending]; pending != nil {
+		ring.Pending = pending.caPair
+	}
+	if previous := stages[secretStagePrevious]; previous != nil {
+		ring.Previous = previous.caPair
+	}
+	return ring, nil
+}
+
+func (s *SecretsManagerCAStore) CreateCA(certType protocol.CertificateType, caPair *schismCrypt.EncodedCaPair) error {
+	caPairJson, err := json.Marshal(caPair)
+	if err != nil {
+		return err
+	}
+	createInput := &secretsmanager.CreateSecretInput{
+		Name:         aws.String(s.Config.CaParamName(certType)),
+		Description:  aws.String("CA Certificate used to sign ssh certificates"),
+		SecretString: aws.String(string(caPairJson)),
+	}
+	if len(s.Config.CaSsmKmsKeyId) > 0 {
+		createInput.KmsKeyId = aws.String(s.Config.CaSsmKmsKeyId)
+	}
+	_, err = s.SecretsSvc.CreateSecret(createInput)
+	return err
+}
+
+// SaveKeyRing moves the stage labels to match keyRing, only adding versions for
+// keys the secret doesn't hold yet. AWSCURRENT goes first since Secrets Manager
+// hands AWSPREVIOUS to whichever version loses it.
+func (s *SecretsManagerCAStore) SaveKeyRing(certType protocol.CertificateType, keyRing *schismCrypt.CaKeyRing) error {
+	if keyRing.Current == nil {
+		return errors.New("refusing to save a CA key ring without a current CA")
+	}
+	secretName := s.Config.CaParamName(certType)
+	stages, err := s.loadStages(secretName)
+	if err != nil {
+		return err
+	}
+	versionIds := map[string]string{}
+	for _, version := range stages {
+		versionIds[version.caPair.Fingerprint] = version.id
+	}
+	slots := []struct {
+		stage  string
+		caPair *schismCrypt.EncodedCaPair
+	}{
+		{secretStageCurrent, keyRing.Current},
+		{secretStagePending, keyRing.Pending},
+		{secretStagePrevious, keyRing.Previous},
+	}
+	for _, slot := range slots {
+		attached := stages[slot.stage]
+		if slot.caPair == nil {
+			if attached != nil {
+				if err := s.moveStage(secretName, slot.stage, "", attached.id); err != nil {
+					return err
+				}
+				delete(stages, slot.stage)
+			}
+			continue
+		}
+		if attached != nil && attached.caPair.Fingerprint == slot.caPair.Fingerprint {
+			continue
+		}
+		versionId, known := versionIds[slot.caPair.Fingerprint]
+		if known {
+			removeFrom := ""
+			if attached != nil {
+				removeFrom = attached.id
+			}
+			err = s.moveStage(secretName, slot.stage, versionId, removeFrom)
+		} else {
+			versionId, err = s.putVersion(secretName, slot.stage, slot.caPair)
+			versionIds[slot.caPair.Fingerprint] = versionId
+		}
+		if err != nil {
+			return err
+		}
+		if slot.stage == secretStageCurrent && attached != nil {
+			stages[secretStagePrevious] = attached
+		}
+		stages[slot.stage] = &secretVersion{id: versionId, caPair: slot.caPair}
+	}
+	return nil
+}
+
+// loadStages skips stages the secret doesn't have, a missing secret has none
+func (s *SecretsManagerCAStore) loadStages(secretName string) (map[string]*secretVersion, error) {
+	stages := map[string]*secretVersion{}
+	for _, stage := range []string{secretStageCurrent, secretStagePending, secretStagePrevious} {
+		secretOutput, err := s.SecretsSvc.GetSecretValue(&secretsmanager.GetSecretValueInput{
+			SecretId:     aws.String(secretName),
+			VersionStage: aws.String(stage),
+		})
+		if IsSecretNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		caPair := &schismCrypt.EncodedCaPair{}
+		if err := json.Unmarshal([]byte(aws.StringValue(secretOutput.SecretString)), caPair); err != nil {
+			return nil, err
+		}
+		if err := caPair.Verify(); err != nil {
+			return nil, fmt.Errorf("CA in '%s' (%s) failed verification: %w", secretName, stage, err)
+		}
+		stages[stage] = &secretVersion{id: aws.StringValue(secretOutput.VersionId), caPair: caPair}
+	}
+	return stages, nil
+}
+
+func (s *SecretsManagerCAStore) putVersion(secretName string, stage string, caPair *schismCrypt.EncodedCaPair) (string, error) {
+	caPairJson, err := json.Marshal(caPair)
+	if err != nil {
+		return "", err
+	}
+	putOutput, err := s.SecretsSvc.PutSecretValue(&secretsmanager.PutSecretValueInput{
+		SecretId:      aws.String(secretName),
+		SecretString:  aws.String(string(caPairJson)),
+		VersionStages: aws.StringSlice([]string{stage}),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(putOutput.VersionId), nil
+}
+
+func (s *SecretsManagerCAStore) moveStage(secretName string, stage string, moveTo string, removeFrom string) error {
+	updateInput := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:     aws.String(secretName),
+		VersionStage: aws.String(stage),
+	}
+	if moveTo != "" {
+		updateInput.MoveToVersionId = aws.String(moveTo)
+	}
+	if removeFrom != "" {
+		updateInput.RemoveFromVersionId = aws.String(removeFrom)
+	}
+	_, err := s.SecretsSvc.UpdateSecretVersionStage(updateInput)
+	return err
+}
@@ -0,0 +1,180 @@
+package cloud
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+
+	"code.agarg.me/schism/commonLib/protocol"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+type fakeSecret struct {
+	kmsKeyId string
+	versions map[string]string
+	stages   map[string]string
+}
+
+// fakeSecretsManagerClient follows Secrets Manager's staging label rules,
+// including AWSPREVIOUS following AWSCURRENT around
+type fakeSecretsManagerClient struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets  map[string]*fakeSecret
+	versions int
+}
+
+func newFakeSecretsManagerClient() *fakeSecretsManagerClient {
+	return &fakeSecretsManagerClient{secrets: map[string]*fakeSecret{}}
+}
+
+func secretNotFound() error {
+	return awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+}
+
+func (f *fakeSecretsManagerClient) newVersion(secret *fakeSecret, value string) string {
+	f.versions++
+	versionId := fmt.Sprintf("version-%032d", f.versions)
+	secret.versions[versionId] = value
+	return versionId
+}
+
+func (f *fakeSecretsManagerClient) attach(secret *fakeSecret, stage string, versionId string) {
+	if stage == secretStageCurrent {
+		if oldCurrent, ok := secret.stages[secretStageCurrent]; ok && oldCurrent != versionId {
+			secret.stages[secretStagePrevious] = oldCurrent
+		}
+	}
+	secret.stages[stage] = versionId
+}
+
+func (f *fakeSecretsManagerClient) CreateSecret(input *secretsmanager.CreateSecretInput) (*secretsmanager.CreateSecretOutput, error) {
+	if _, exists := f.secrets[*input.Name]; exists {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceExistsException, "secret already exists", nil)
+	}
+	secret := &fakeSecret{kmsKeyId: aws.StringValue(input.KmsKeyId), versions: map[string]string{}, stages: map[string]string{}}
+	f.secrets[*input.Name] = secret
+	versionId := f.newVersion(secret, *input.SecretString)
+	f.attach(secret, secretStageCurrent, versionId)
+	return &secretsmanager.CreateSecretOutput{Name: input.Name, VersionId: aws.String(versionId)}, nil
+}
+
+func (f *fakeSecretsManagerClient) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	secret, ok := f.secrets[*input.SecretId]
+	if !ok {
+		return nil, secretNotFound()
+	}
+	versionId, ok := secret.stages[aws.StringValue(input.VersionStage)]
+	if !ok {
+		return nil, secretNotFound()
+	}
+	return &secretsmanager.GetSecretValueOutput{
+		Name:         input.SecretId,
+		VersionId:    aws.String(versionId),
+		SecretString: aws.String(secret.versions[versionId]),
+	}, nil
+}
+
+func (f *fakeSecretsManagerClient) PutSecretValue(input *secretsmanager.PutSecretValueInput) (*secretsmanager.PutSecretValueOutput, error) {
+	secret, ok := f.secrets[*input.SecretId]
+	if !ok {
+		return nil, secretNotFound()
+	}
+	versionId := f.newVersion(secret, *input.SecretString)
+	stages := aws.StringValueSlice(input.VersionStages)
+	if len(stages) == 0 {
+		stages = []string{secretStageCurrent}
+	}
+	for _, stage := range stages {
+		f.attach(secret, stage, versionId)
+	}
+	return &secretsmanager.PutSecretValueOutput{Name: input.SecretId, VersionId: aws.String(versionId)}, nil
+}
+
+func (f *fakeSecretsManagerClient) UpdateSecretVersionStage(input *secretsmanager.UpdateSecretVersionStageInput) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	secret, ok := f.secrets[*input.SecretId]
+	if !ok {
+		return nil, secretNotFound()
+	}
+	stage := *input.VersionStage
+	attachedTo, attached := secret.stages[stage]
+	if attached && attachedTo != aws.StringValue(input.RemoveFromVersionId) {
+		return nil, awserr.New(secretsmanager.ErrCodeInvalidParameterException,
+			fmt.Sprintf("%s is attached to %s, not %s", stage, attachedTo, aws.StringValue(input.RemoveFromVersionId)), nil)
+	}
+	if input.MoveToVersionId == nil {
+		if stage == secretStageCurrent {
+			return nil, awserr.New(secretsmanager.ErrCodeInvalidParameterException, "AWSCURRENT can only be moved", nil)
+		}
+		delete(secret.stages, stage)
+	} else {
+		if _, ok := secret.versions[*input.MoveToVersionId]; !ok {
+			return nil, secretNotFound()
+		}
+		f.attach(secret, stage, *input.MoveToVersionId)
+	}
+	return &secretsmanager.UpdateSecretVersionStageOutput{Name: input.SecretId}, nil
+}
+
+func TestSecretsManagerCAStore(t *testing.T) {
+	secretsSvc := newFakeSecretsManagerClient()
+	store := &SecretsManagerCAStore{
+		SecretsSvc: secretsSvc,
+		Config:     SchismConfig{CaParamPrefix: "schism-test", CaSsmKmsKeyId: "alias/schism-ca"},
+	}
+	if _, err := store.LoadKeyRing(protocol.HostCertificate); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LoadKeyRing() error = %v, want %v", err, ErrNotFound)
+	}
+	caPair, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	if err := store.CreateCA(protocol.HostCertificate, caPair); err != nil {
+		t.Fatalf("CreateCA() error = %v", err)
+	}
+	if err := store.CreateCA(protocol.HostCertificate, caPair); err == nil {
+		t.Errorf("CreateCA() overwrote an existing CA")
+	}
+	if got := secretsSvc.secrets["schism-test-host"].kmsKeyId; got != "alias/schism-ca" {
+		t.Errorf("CreateCA() KmsKeyId = %v, want alias/schism-ca", got)
+	}
+
+	keyRing, err := store.LoadKeyRing(protocol.HostCertificate)
+	if err != nil {
+		t.Fatalf("LoadKeyRing() error = %v", err)
+	}
+	tests := []struct {
+		step      string
+		wantState string
+	}{
+		{step: crypto.RotationStepGenerate, wantState: crypto.RotationPending},
+		{step: crypto.RotationStepPromote, wantState: crypto.RotationPromoted},
+		{step: crypto.RotationStepRetire, wantState: crypto.RotationIdle},
+		{step: crypto.RotationStepGenerate, wantState: crypto.RotationPending},
+		{step: crypto.RotationStepPromote, wantState: crypto.RotationPromoted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			if err := keyRing.Rotate(tt.step, crypto.CAKeyAlgoED25519); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SaveKeyRing(protocol.HostCertificate, keyRing); err != nil {
+				t.Fatalf("SaveKeyRing() error = %v", err)
+			}
+			got, err := store.LoadKeyRing(protocol.HostCertificate)
+			if err != nil {
+				t.Fatalf("LoadKeyRing() error = %v", err)
+			}
+			if got.State() != tt.wantState {
+				t.Errorf("LoadKeyRing() state = %v, want %v", got.State(), tt.wantState)
+			}
+			if fmt.Sprint(got.Fingerprints()) != fmt.Sprint(keyRing.Fingerprints()) {
+				t.Errorf("LoadKeyRing() fingerprints = %v, want %v", got.Fingerprints(), keyRing.Fingerprints())
+			}
+		})
+	}
+	if versions := len(secretsSvc.secrets["schism-test-host"].versions); versions != 3 {
+		t.Errorf("rotations left %d versions, want one per generated key (3)", versions)
+	}
+}
@@ -0,0 +1,75 @@
+package cloud
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const serialAllocationAttempts = 10
+
+type SerialCounterS3Object struct {
+	Next uint64 `json:"next"`
+}
+
+func (c *SerialCounterS3Object) ObjectKey(prefix string) string {
+	return prefix + "Serials/counter.json"
+}
+
+type SerialRecordS3Object struct {
+	Serial    uint64    `json:"serial"`
+	LookupKey string    `json:"lookup_key"`
+	IssuedOn  time.Time `json:"issued_on"`
+}
+
+func (r *SerialRecordS3Object) ObjectKey(prefix string) string {
+	return fmt.Sprintf("%sSerials/%d.json", prefix, r.Serial)
+}
+
+// S3SerialAllocator hands out monotonic serials from a counter object guarded
+// by conditional writes, then records each serial against its lookup key.
+type S3SerialAllocator struct {
+	S3Svc  s3iface.S3API
+	Config SchismConfig
+}
+
+func (a *S3SerialAllocator) AllocateSerial(lookupKey string) (uint64, error) {
+	for attempt := 0; attempt < serialAllocationAttempts; attempt++ {
+		counter := &SerialCounterS3Object{}
+		etag, err := LoadS3Object(a.S3Svc, a.Config, counter)
+		if err != nil && !IsS3NotFound(err) {
+			return 0, err
+		}
+		if counter.Next == 0 {
+			counter.Next = 1
+		}
+		serial := counter.Next
+		counter.Next++
+		_, err = SaveS3ObjectIfMatch(a.S3Svc, a.Config, counter, etag)
+		if errors.Is(err, ErrS3PreconditionFailed) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		record := &SerialRecordS3Object{Serial: serial, LookupKey: lookupKey, IssuedOn: time.Now().UTC()}
+		_, err = SaveS3ObjectIfMatch(a.S3Svc, a.Config, record, "")
+		if errors.Is(err, ErrS3PreconditionFailed) {
+			// the counter was reset behind our back, skip past serials already on record
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		return serial, nil
+	}
+	return 0, fmt.Errorf("unable to allocate a certificate serial after %d attempts", serialAllocationAttempts)
+}
+
+func LoadSerialRecord(s3Svc s3iface.S3API, config SchismConfig, serial uint64) (*SerialRecordS3Object, error) {
+	record := &SerialRecordS3Object{Serial: serial}
+	if _, err := LoadS3Object(s3Svc, config, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,244 @@
+package cloud
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib"
+	"code.agarg.me/schism/commonLib/protocol"
+
+	schismCrypt "code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+// ErrNotFound is matched by every store's "nothing there yet" error
+var ErrNotFound = errors.New("not found")
+
+type notFoundError struct {
+	err error
+}
+
+func (e notFoundError) Error() string        { return e.err.Error() }
+func (e notFoundError) Unwrap() error        { return e.err }
+func (e notFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// CAStore keeps a namespace's host and user CA key rings
+type CAStore interface {
+	// LoadKeyRing fails with ErrNotFound until certType has a CA
+	LoadKeyRing(certType protocol.CertificateType) (*schismCrypt.CaKeyRing, error)
+	// CreateCA never overwrites an existing CA
+	CreateCA(certType protocol.CertificateType, caPair *schismCrypt.EncodedCaPair) error
+	SaveKeyRing(certType protocol.CertificateType, keyRing *schismCrypt.CaKeyRing) error
+}
+
+// CertStore holds everything published next to the issued certificates: the
+// certificates themselves, CA public keys, revocations, serials and challenges
+type CertStore interface {
+	SaveCertificate(record *SignedCertificateRecord) (string, error)
+	// LoadCertificate fails with ErrNotFound for an unknown lookup key
+	LoadCertificate(lookupKey string) (*SignedCertificateRecord, error)
+	PublishCA(caObject *protocol.CAPublicKeyS3Object) (string, error)
+
+	LoadRevocationList(certType protocol.CertificateType) (*RevocationListS3Object, error)
+	RecordRevocation(certType protocol.CertificateType, caKeys []ssh.PublicKey, revocation Revocation) (*RevocationListS3Object, error)
+	PublishKRL(list *RevocationListS3Object, caKeys []ssh.PublicKey) (string, error)
+	PublishMissingKRL(list *RevocationListS3Object, caKeys []ssh.PublicKey) (string, error)
+
+	Serials() schismCrypt.SerialAllocator
+	// LoadSerialRecord fails with ErrNotFound for a serial that was never issued
+	LoadSerialRecord(serial uint64) (*SerialRecordS3Object, error)
+
+	IssueChallenge(pubKey ssh.PublicKey, ttl time.Duration) (*ChallengeS3Object, error)
+	ConsumeChallenge(nonce string, pubKey ssh.PublicKey, armoredSig []byte) error
+}
+
+// NewCAStore picks the CaBackend implementation
+func NewCAStore(config SchismConfig, region string) (CAStore, error) {
+	switch config.CaBackend {
+	case CaBackendSSM:
+		return &SSMCAStore{SSMSvc: commonLib.SSMClient(region), Config: config}, nil
+	case CaBackendKMS:
+		return &KMSCAStore{KMSSvc: KMSClient(region), Config: config}, nil
+	case CaBackendSecretsManager:
+		return &SecretsManagerCAStore{SecretsSvc: SecretsManagerClient(region), Config: config}, nil
+	default:
+		return nil, fmt.Errorf("unknown %s: %s", CaBackendEnvVar, config.CaBackend)
+	}
+}
+
+// NewCertStore picks the CertBackend implementation
+func NewCertStore(config SchismConfig, region string) (CertStore, error) {
+	switch config.CertBackend {
+	case CertBackendS3:
+		return &S3CertStore{S3Svc: commonLib.S3Client(region), Config: config}, nil
+	default:
+		return nil, fmt.Errorf("unknown %s: %s", CertBackendEnvVar, config.CertBackend)
+	}
+}
+
+// DocumentStore fetches the policy documents the *Source settings point at
+type DocumentStore interface {
+	LoadDocument(source string) ([]byte, error)
+}
+
+// KeySealer seals generated private keys with a data key of the KMS key keyId
+type KeySealer interface {
+	SealWithKMS(keyId string, plaintext []byte) (*KMSSealedBox, []byte, error)
+}
+
+func NewDocumentStore(region string) DocumentStore {
+	return &AWSDocumentStore{SSMSvc: commonLib.SSMClient(region), S3Svc: commonLib.S3Client(region)}
+}
+
+func NewKeySealer(region string) KeySealer {
+	return &KMSKeySealer{KMSSvc: KMSClient(region)}
+}
+
+// AWSDocumentStore reads "ssm:<parameter>" and "s3://<bucket>/<key>" sources
+type AWSDocumentStore struct {
+	SSMSvc ssmiface.SSMAPI
+	S3Svc  s3iface.S3API
+}
+
+func (s *AWSDocumentStore) LoadDocument(source string) ([]byte, error) {
+	return LoadDocument(s.SSMSvc, s.S3Svc, source)
+}
+
+type KMSKeySealer struct {
+	KMSSvc kmsiface.KMSAPI
+}
+
+func (s *KMSKeySealer) SealWithKMS(keyId string, plaintext []byte) (*KMSSealedBox, []byte, error) {
+	return SealWithKMS(s.KMSSvc, keyId, plaintext)
+}
+
+// SSMCAStore keeps each CA as a SecureString parameter named by CaParamName,
+// with rotation keys in the "-pending" and "-previous" parameters
+type SSMCAStore struct {
+	SSMSvc ssmiface.SSMAPI
+	Config SchismConfig
+}
+
+func (s *SSMCAStore) LoadKeyRing(certType protocol.CertificateType) (*schismCrypt.CaKeyRing, error) {
+	keyRing, err := LoadCaKeyRingFromSSM(s.SSMSvc, s.Config.CaParamName(certType))
+	if IsSSMNotFound(err) {
+		return nil, notFoundError{err}
+	}
+	return keyRing, err
+}
+
+func (s *SSMCAStore) CreateCA(certType protocol.CertificateType, caPair *schismCrypt.EncodedCaPair) error {
+	return SaveCAToSSM(s.SSMSvc, caPair, s.Config.CaParamName(certType), s.Config.CaSsmKmsKeyId)
+}
+
+func (s *SSMCAStore) SaveKeyRing(certType protocol.CertificateType, keyRing *schismCrypt.CaKeyRing) error {
+	return SaveCaKeyRingToSSM(s.SSMSvc, keyRing, s.Config.CaParamName(certType), s.Config.CaSsmKmsKeyId)
+}
+
+// KMSCAStore signs with asymmetric KMS keys. It can't create or rotate them, and
+// since only the public halves are read the key rings are cached.
+type KMSCAStore struct {
+	KMSSvc kmsiface.KMSAPI
+	Config SchismConfig
+
+	keyRings map[protocol.CertificateType]*schismCrypt.CaKeyRing
+}
+
+var ErrCAStoreReadOnly = errors.New("the CA backend manages its own keys")
+
+func (s *KMSCAStore) LoadKeyRing(certType protocol.CertificateType) (*schismCrypt.CaKeyRing, error) {
+	if keyRing, ok := s.keyRings[certType]; ok {
+		return keyRing, nil
+	}
+	if s.Config.KmsHostCaKeyId == "" || s.Config.KmsUserCaKeyId == "" {
+		return nil, fmt.Errorf("both %s and %s are required for the kms CA backend",
+			KmsHostCaKeyIdEnvVar, KmsUserCaKeyIdEnvVar)
+	}
+	keyId := s.Config.KmsUserCaKeyId
+	if certType == protocol.HostCertificate {
+		keyId = s.Config.KmsHostCaKeyId
+	}
+	keyRing, err := LoadCaKeyRingFromKMS(s.KMSSvc, keyId)
+	if err != nil {
+		return nil, err
+	}
+	if s.keyRings == nil {
+		s.keyRings = map[protocol.CertificateType]*schismCrypt.CaKeyRing{}
+	}
+	s.keyRings[certType] = keyRing
+	return keyRing, nil
+}
+
+func (s *KMSCAStore) CreateCA(protocol.CertificateType, *schismCrypt.EncodedCaPair) error {
+	return fmt.Errorf("%w, create the KMS key and point %s/%s at it", ErrCAStoreReadOnly,
+		KmsHostCaKeyIdEnvVar, KmsUserCaKeyIdEnvVar)
+}
+
+func (s *KMSCAStore) SaveKeyRing(protocol.CertificateType, *schismCrypt.CaKeyRing) error {
+	return fmt.Errorf("%w, point %s/%s at a new KMS key instead", ErrCAStoreReadOnly,
+		KmsHostCaKeyIdEnvVar, KmsUserCaKeyIdEnvVar)
+}
+
+// S3CertStore keeps everything as JSON objects under CertsS3Prefix in CertsS3Bucket
+type S3CertStore struct {
+	S3Svc  s3iface.S3API
+	Config SchismConfig
+}
+
+func (s *S3CertStore) SaveCertificate(record *SignedCertificateRecord) (string, error) {
+	return SaveS3Object(s.S3Svc, s.Config, record)
+}
+
+func (s *S3CertStore) LoadCertificate(lookupKey string) (*SignedCertificateRecord, error) {
+	record, _, err := LoadSignedCertificate(s.S3Svc, s.Config, lookupKey)
+	if IsS3NotFound(err) {
+		return nil, notFoundError{err}
+	}
+	return record, err
+}
+
+func (s *S3CertStore) PublishCA(caObject *protocol.CAPublicKeyS3Object) (string, error) {
+	return SaveS3Object(s.S3Svc, s.Config, caObject)
+}
+
+func (s *S3CertStore) LoadRevocationList(certType protocol.CertificateType) (*RevocationListS3Object, error) {
+	list, _, err := LoadRevocationList(s.S3Svc, s.Config, certType)
+	return list, err
+}
+
+func (s *S3CertStore) RecordRevocation(certType protocol.CertificateType, caKeys []ssh.PublicKey, revocation Revocation) (*RevocationListS3Object, error) {
+	return RecordRevocation(s.S3Svc, s.Config, certType, caKeys, revocation)
+}
+
+func (s *S3CertStore) PublishKRL(list *RevocationListS3Object, caKeys []ssh.PublicKey) (string, error) {
+	return PublishKRL(s.S3Svc, s.Config, list, caKeys)
+}
+
+func (s *S3CertStore) PublishMissingKRL(list *RevocationListS3Object, caKeys []ssh.PublicKey) (string, error) {
+	return PublishMissingKRL(s.S3Svc, s.Config, list, caKeys)
+}
+
+func (s *S3CertStore) Serials() schismCrypt.SerialAllocator {
+	return &S3SerialAllocator{S3Svc: s.S3Svc, Config: s.Config}
+}
+
+func (s *S3CertStore) LoadSerialRecord(serial uint64) (*SerialRecordS3Object, error) {
+	record, err := LoadSerialRecord(s.S3Svc, s.Config, serial)
+	if IsS3NotFound(err) {
+		return nil, notFoundError{err}
+	}
+	return record, err
+}
+
+func (s *S3CertStore) IssueChallenge(pubKey ssh.PublicKey, ttl time.Duration) (*ChallengeS3Object, error) {
+	return IssueChallenge(s.S3Svc, s.Config, pubKey, ttl)
+}
+
+func (s *S3CertStore) ConsumeChallenge(nonce string, pubKey ssh.PublicKey, armoredSig []byte) error {
+	return ConsumeChallenge(s.S3Svc, s.Config, nonce, pubKey, armoredSig)
+}
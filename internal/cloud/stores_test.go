@@ -0,0 +1,139 @@
+package cloud
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+
+	"code.agarg.me/schism/commonLib/protocol"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestSSMCAStore(t *testing.T) {
+	store := &SSMCAStore{SSMSvc: newFakeSSMClient(), Config: SchismConfig{CaParamPrefix: "schism-test"}}
+	if _, err := store.LoadKeyRing(protocol.UserCertificate); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LoadKeyRing() error = %v, want %v", err, ErrNotFound)
+	}
+	caPair, _ := crypto.CreateCA(crypto.CAKeyAlgoED25519)
+	if err := store.CreateCA(protocol.UserCertificate, caPair); err != nil {
+		t.Fatalf("CreateCA() error = %v", err)
+	}
+	if err := store.CreateCA(protocol.UserCertificate, caPair); err == nil {
+		t.Errorf("CreateCA() overwrote an existing CA")
+	}
+	keyRing, err := store.LoadKeyRing(protocol.UserCertificate)
+	if err != nil || keyRing.Current.Fingerprint != caPair.Fingerprint {
+		t.Fatalf("LoadKeyRing() got = %v, err = %v, want %s", keyRing, err, caPair.Fingerprint)
+	}
+	if err := keyRing.Rotate(crypto.RotationStepGenerate, crypto.CAKeyAlgoED25519); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveKeyRing(protocol.UserCertificate, keyRing); err != nil {
+		t.Fatalf("SaveKeyRing() error = %v", err)
+	}
+	reloaded, err := store.LoadKeyRing(protocol.UserCertificate)
+	if err != nil || reloaded.Pending == nil || reloaded.Pending.Fingerprint != keyRing.Pending.Fingerprint {
+		t.Errorf("LoadKeyRing() after a rotation got = %+v, err = %v", reloaded, err)
+	}
+	if _, err := store.LoadKeyRing(protocol.HostCertificate); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LoadKeyRing() host error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestKMSCAStore(t *testing.T) {
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	kmsSvc := &mockKMSClient{key: p256Key, keySpec: kms.KeySpecEccNistP256, keyUsage: kms.KeyUsageTypeSignVerify}
+	store := &KMSCAStore{KMSSvc: kmsSvc, Config: SchismConfig{KmsHostCaKeyId: "alias/host", KmsUserCaKeyId: "alias/user"}}
+
+	keyRing, err := store.LoadKeyRing(protocol.HostCertificate)
+	if err != nil {
+		t.Fatalf("LoadKeyRing() error = %v", err)
+	}
+	if cached, _ := store.LoadKeyRing(protocol.HostCertificate); cached != keyRing {
+		t.Errorf("LoadKeyRing() did not cache the key ring")
+	}
+	if err := store.CreateCA(protocol.HostCertificate, keyRing.Current); !errors.Is(err, ErrCAStoreReadOnly) {
+		t.Errorf("CreateCA() error = %v, want %v", err, ErrCAStoreReadOnly)
+	}
+	if err := store.SaveKeyRing(protocol.HostCertificate, keyRing); !errors.Is(err, ErrCAStoreReadOnly) {
+		t.Errorf("SaveKeyRing() error = %v, want %v", err, ErrCAStoreReadOnly)
+	}
+	unconfigured := &KMSCAStore{KMSSvc: kmsSvc, Config: SchismConfig{KmsHostCaKeyId: "alias/host"}}
+	if _, err := unconfigured.LoadKeyRing(protocol.UserCertificate); err == nil {
+		t.Errorf("LoadKeyRing() without both key ids succeeded")
+	}
+}
+
+func TestS3CertStore(t *testing.T) {
+	store := &S3CertStore{S3Svc: newFakeS3Client(), Config: SchismConfig{CertsS3Bucket: "schism-test", CertsS3Prefix: "test/"}}
+	if _, err := store.LoadCertificate("user:missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LoadCertificate() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := store.LoadSerialRecord(42); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LoadSerialRecord() error = %v, want %v", err, ErrNotFound)
+	}
+	serial, err := store.Serials().AllocateSerial("user:abc")
+	if err != nil {
+		t.Fatalf("AllocateSerial() error = %v", err)
+	}
+	if got, err := store.LoadSerialRecord(serial); err != nil || got.LookupKey != "user:abc" {
+		t.Errorf("LoadSerialRecord() got = %+v, err = %v", got, err)
+	}
+	record := &SignedCertificateRecord{
+		SignedCertificateS3Object: protocol.SignedCertificateS3Object{CertificateType: protocol.UserCertificate, Identity: "alice"},
+		LookupKey:                 "user:abc",
+		Serial:                    serial,
+	}
+	if _, err := store.SaveCertificate(record); err != nil {
+		t.Fatalf("SaveCertificate() error = %v", err)
+	}
+	if got, err := store.LoadCertificate("user:abc"); err != nil || got.Serial != serial || got.Identity != "alice" {
+		t.Errorf("LoadCertificate() got = %+v, err = %v", got, err)
+	}
+	list, err := store.LoadRevocationList(protocol.UserCertificate)
+	if err != nil || list.Version != 0 || len(list.Revocations) != 0 {
+		t.Errorf("LoadRevocationList() got = %+v, err = %v, want an empty list", list, err)
+	}
+}
+
+func TestNewCAStore(t *testing.T) {
+	tests := []struct {
+		name      string
+		caBackend string
+		want      CAStore
+		wantErr   bool
+	}{
+		{name: "ssm", caBackend: CaBackendSSM, want: &SSMCAStore{}},
+		{name: "kms", caBackend: CaBackendKMS, want: &KMSCAStore{}},
+		{name: "secretsmanager", caBackend: CaBackendSecretsManager, want: &SecretsManagerCAStore{}},
+		{name: "unknown backend", caBackend: "vault", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewCAStore(SchismConfig{CaBackend: tt.caBackend}, "us-east-1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewCAStore() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("NewCAStore() got = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCertStore(t *testing.T) {
+	if got, err := NewCertStore(SchismConfig{CertBackend: CertBackendS3}, "us-east-1"); err != nil {
+		t.Errorf("NewCertStore() error = %v", err)
+	} else if _, ok := got.(*S3CertStore); !ok {
+		t.Errorf("NewCertStore() got = %T, want *S3CertStore", got)
+	}
+	if _, err := NewCertStore(SchismConfig{CertBackend: "gcs"}, "us-east-1"); err == nil {
+		t.Errorf("NewCertStore() accepted an unknown backend")
+	}
+}
@@ -1,34 +1,189 @@
 package cloud
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	schismCrypt "code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+const (
+	CaKeyAlgorithmEnvVar            = "SCHISM_CA_KEY_ALGORITHM"
+	CaSsmKmsKeyIdEnvVar             = "SCHISM_CA_KMS_KEY_ID"
+	CaParamPrefixEnvVar             = "SCHISM_CA_PARAM_PREFIX"
+	CertBackdateEnvVar              = "SCHISM_CERT_BACKDATE"
+	CertsS3BucketEnvVar             = "SCHISM_CERTS_S3_BUCKET"
+	CertsS3PrefixEnvVar             = "SCHISM_CERTS_S3_PREFIX"
+	HostCertsAuthDomainEnvVar       = "SCHISM_HOST_CA_AUTH_DOMAIN"
+	CaBackendEnvVar                 = "SCHISM_CA_BACKEND"
+	KmsHostCaKeyIdEnvVar            = "SCHISM_KMS_HOST_CA_KEY_ID"
+	KmsUserCaKeyIdEnvVar            = "SCHISM_KMS_USER_CA_KEY_ID"
+	KeyAllowedAlgorithmsEnvVar      = "SCHISM_KEY_ALLOWED_ALGORITHMS"
+	KeyMinRSABitsEnvVar             = "SCHISM_KEY_MIN_RSA_BITS"
+	KeyAllowedECDSACurvesEnvVar     = "SCHISM_KEY_ALLOWED_ECDSA_CURVES"
+	UserKeyRequireSecurityKeyEnvVar = "SCHISM_USER_KEY_REQUIRE_SECURITY_KEY"
+	PrincipalRulesSourceEnvVar      = "SCHISM_PRINCIPAL_RULES_SOURCE"
+	PolicyCacheTTLEnvVar            = "SCHISM_POLICY_CACHE_TTL"
+	HostCertDefaultTTLEnvVar        = "SCHISM_HOST_CERT_DEFAULT_TTL"
+	HostCertMaxTTLEnvVar            = "SCHISM_HOST_CERT_MAX_TTL"
+	UserCertDefaultTTLEnvVar        = "SCHISM_USER_CERT_DEFAULT_TTL"
+	UserCertMaxTTLEnvVar            = "SCHISM_USER_CERT_MAX_TTL"
+	PrincipalMaxTTLsEnvVar          = "SCHISM_PRINCIPAL_MAX_TTLS"
+	TTLModeEnvVar                   = "SCHISM_TTL_MODE"
+	SigningProfilesSourceEnvVar     = "SCHISM_SIGNING_PROFILES_SOURCE"
+	KeyIDTemplateEnvVar             = "SCHISM_KEY_ID_TEMPLATE"
+	RequireProofOfPossessionEnvVar  = "SCHISM_REQUIRE_PROOF_OF_POSSESSION"
+	ChallengeTTLEnvVar              = "SCHISM_CHALLENGE_TTL"
+	HostAllowedDomainsEnvVar        = "SCHISM_HOST_ALLOWED_DOMAINS"
+	HostAllowedCIDRsEnvVar          = "SCHISM_HOST_ALLOWED_CIDRS"
+	HostAllowedNamesEnvVar          = "SCHISM_HOST_ALLOWED_NAMES"
+	CaNamespacesSourceEnvVar        = "SCHISM_CA_NAMESPACES_SOURCE"
+	GeneratedKeyKmsKeyIdEnvVar      = "SCHISM_GENERATED_KEY_KMS_KEY_ID"
+	RenewalGracePeriodEnvVar        = "SCHISM_RENEWAL_GRACE_PERIOD"
+	CertBackendEnvVar               = "SCHISM_CERT_BACKEND"
+	CertMaxStartDelayEnvVar         = "SCHISM_CERT_MAX_START_DELAY"
+
+	CaKeyAlgorithmDefault     = schismCrypt.CAKeyAlgoED25519
+	CaParamPrefixDefault      = "schism-"
+	CertBackdateDefault       = time.Minute
+	CertsS3BucketDefault      = "schism-signed-certificates"
+	CaBackendDefault          = CaBackendSSM
+	PolicyCacheTTLDefault     = 5 * time.Minute
+	HostCertDefaultTTLDefault = 30 * 24 * time.Hour
+	HostCertMaxTTLDefault     = 365 * 24 * time.Hour
+	UserCertDefaultTTLDefault = 8 * time.Hour
+	UserCertMaxTTLDefault     = 24 * time.Hour
+	TTLModeDefault            = schismCrypt.TTLModeClamp
+	ChallengeTTLDefault       = 5 * time.Minute
+	RenewalGracePeriodDefault = 24 * time.Hour
+	CertBackendDefault        = CertBackendS3
+	CertMaxStartDelayDefault  = 7 * 24 * time.Hour
 )
 
 const (
-	CaSsmKmsKeyIdEnvVar       = "SCHISM_CA_KMS_KEY_ID"
-	CaParamPrefixEnvVar       = "SCHISM_CA_PARAM_PREFIX"
-	CertsS3BucketEnvVar       = "SCHISM_CERTS_S3_BUCKET"
-	CertsS3PrefixEnvVar       = "SCHISM_CERTS_S3_PREFIX"
-	HostCertsAuthDomainEnvVar = "SCHISM_HOST_CA_AUTH_DOMAIN"
-
-	CaParamPrefixDefault = "schism-"
-	CertsS3BucketDefault = "schism-signed-certificates"
+	CaBackendSSM            = "ssm"
+	CaBackendKMS            = "kms"
+	CaBackendSecretsManager = "secretsmanager"
+
+	CertBackendS3 = "s3"
 )
 
 type SchismConfig struct {
-	CaSsmKmsKeyId       string
-	CaParamPrefix       string
-	CertsS3Bucket       string
-	CertsS3Prefix       string
-	HostCertsAuthDomain string
+	CaKeyAlgorithm            string
+	CaSsmKmsKeyId             string
+	CaParamPrefix             string
+	CertBackdate              time.Duration
+	CertsS3Bucket             string
+	CertsS3Prefix             string
+	HostCertsAuthDomain       string
+	CaBackend                 string
+	KmsHostCaKeyId            string
+	KmsUserCaKeyId            string
+	KeyAllowedAlgorithms      []string
+	KeyMinRSABits             int
+	KeyAllowedECDSACurves     []string
+	UserKeyRequireSecurityKey bool
+	PrincipalRulesSource      string
+	PolicyCacheTTL            time.Duration
+	HostCertDefaultTTL        time.Duration
+	HostCertMaxTTL            time.Duration
+	UserCertDefaultTTL        time.Duration
+	UserCertMaxTTL            time.Duration
+	PrincipalMaxTTLs          map[string]time.Duration
+	TTLMode                   string
+	SigningProfilesSource     string
+	KeyIDTemplate             string
+	RequireProofOfPossession  bool
+	ChallengeTTL              time.Duration
+	HostAllowedDomains        []string
+	HostAllowedCIDRs          []string
+	HostAllowedNames          []string
+	CaNamespacesSource        string
+	GeneratedKeyKmsKeyId      string
+	RenewalGracePeriod        time.Duration
+	CertBackend               string
+	CertMaxStartDelay         time.Duration
 }
 
-func (sc *SchismConfig) LoadEnv() {
+// LoadEnv refuses malformed values rather than falling back to a default, a
+// typo in a security setting must not quietly switch it off
+func (sc *SchismConfig) LoadEnv() error {
+	errs := &envErrors{}
+	sc.CaKeyAlgorithm = getEnv(CaKeyAlgorithmEnvVar, CaKeyAlgorithmDefault)
 	sc.CaSsmKmsKeyId = getEnv(CaSsmKmsKeyIdEnvVar, "")
 	sc.CaParamPrefix = getEnv(CaParamPrefixEnvVar, CaParamPrefixDefault)
+	sc.CertBackdate = getEnvDuration(CertBackdateEnvVar, CertBackdateDefault, errs)
 	sc.CertsS3Bucket = getEnv(CertsS3BucketEnvVar, CertsS3BucketDefault)
 	sc.CertsS3Prefix = getEnv(CertsS3PrefixEnvVar, "")
 	sc.HostCertsAuthDomain = getEnv(HostCertsAuthDomainEnvVar, "")
+	sc.CaBackend = getEnv(CaBackendEnvVar, CaBackendDefault)
+	sc.KmsHostCaKeyId = getEnv(KmsHostCaKeyIdEnvVar, "")
+	sc.KmsUserCaKeyId = getEnv(KmsUserCaKeyIdEnvVar, "")
+	sc.KeyAllowedAlgorithms = getEnvList(KeyAllowedAlgorithmsEnvVar, schismCrypt.DefaultAllowedKeyAlgorithms)
+	sc.KeyMinRSABits = getEnvInt(KeyMinRSABitsEnvVar, schismCrypt.DefaultMinRSABits, errs)
+	sc.KeyAllowedECDSACurves = getEnvList(KeyAllowedECDSACurvesEnvVar, schismCrypt.DefaultAllowedECDSACurves)
+	sc.UserKeyRequireSecurityKey = getEnvBool(UserKeyRequireSecurityKeyEnvVar, false, errs)
+	sc.PrincipalRulesSource = getEnv(PrincipalRulesSourceEnvVar, "")
+	sc.PolicyCacheTTL = getEnvDuration(PolicyCacheTTLEnvVar, PolicyCacheTTLDefault, errs)
+	sc.HostCertDefaultTTL = getEnvDuration(HostCertDefaultTTLEnvVar, HostCertDefaultTTLDefault, errs)
+	sc.HostCertMaxTTL = getEnvDuration(HostCertMaxTTLEnvVar, HostCertMaxTTLDefault, errs)
+	sc.UserCertDefaultTTL = getEnvDuration(UserCertDefaultTTLEnvVar, UserCertDefaultTTLDefault, errs)
+	sc.UserCertMaxTTL = getEnvDuration(UserCertMaxTTLEnvVar, UserCertMaxTTLDefault, errs)
+	sc.PrincipalMaxTTLs = getEnvDurationMap(PrincipalMaxTTLsEnvVar, errs)
+	sc.TTLMode = getEnv(TTLModeEnvVar, TTLModeDefault)
+	sc.SigningProfilesSource = getEnv(SigningProfilesSourceEnvVar, "")
+	sc.KeyIDTemplate = getEnv(KeyIDTemplateEnvVar, schismCrypt.DefaultKeyIDTemplate)
+	sc.RequireProofOfPossession = getEnvBool(RequireProofOfPossessionEnvVar, false, errs)
+	sc.ChallengeTTL = getEnvDuration(ChallengeTTLEnvVar, ChallengeTTLDefault, errs)
+	sc.HostAllowedDomains = getEnvList(HostAllowedDomainsEnvVar, nil)
+	sc.HostAllowedCIDRs = getEnvList(HostAllowedCIDRsEnvVar, nil)
+	sc.HostAllowedNames = getEnvList(HostAllowedNamesEnvVar, nil)
+	sc.CaNamespacesSource = getEnv(CaNamespacesSourceEnvVar, "")
+	sc.GeneratedKeyKmsKeyId = getEnv(GeneratedKeyKmsKeyIdEnvVar, "")
+	sc.RenewalGracePeriod = getEnvDuration(RenewalGracePeriodEnvVar, RenewalGracePeriodDefault, errs)
+	sc.CertBackend = getEnv(CertBackendEnvVar, CertBackendDefault)
+	sc.CertMaxStartDelay = getEnvDuration(CertMaxStartDelayEnvVar, CertMaxStartDelayDefault, errs)
+	return errs.err()
+}
+
+func (sc *SchismConfig) CaParamName(certType protocol.CertificateType) string {
+	return fmt.Sprintf("%s-%s", sc.CaParamPrefix, certType)
+}
+
+func (sc *SchismConfig) KeyPolicy() *schismCrypt.KeyPolicy {
+	return &schismCrypt.KeyPolicy{
+		AllowedAlgorithms:  sc.KeyAllowedAlgorithms,
+		MinRSABits:         sc.KeyMinRSABits,
+		AllowedECDSACurves: sc.KeyAllowedECDSACurves,
+		RequireSecurityKey: sc.UserKeyRequireSecurityKey,
+	}
+}
+
+func (sc *SchismConfig) TTLPolicy() *schismCrypt.TTLPolicy {
+	return &schismCrypt.TTLPolicy{
+		Host:         schismCrypt.TTLLimits{Default: sc.HostCertDefaultTTL, Max: sc.HostCertMaxTTL},
+		User:         schismCrypt.TTLLimits{Default: sc.UserCertDefaultTTL, Max: sc.UserCertMaxTTL},
+		PrincipalMax: sc.PrincipalMaxTTLs,
+		Mode:         sc.TTLMode,
+	}
+}
+
+// HostPrincipalPolicy falls back to HostCertsAuthDomain when no domains are listed
+func (sc *SchismConfig) HostPrincipalPolicy() *schismCrypt.HostPrincipalPolicy {
+	allowedDomains := sc.HostAllowedDomains
+	if len(allowedDomains) == 0 && sc.HostCertsAuthDomain != "" {
+		allowedDomains = []string{sc.HostCertsAuthDomain}
+	}
+	return &schismCrypt.HostPrincipalPolicy{
+		AllowedDomains: allowedDomains,
+		AllowedCIDRs:   sc.HostAllowedCIDRs,
+		AllowedNames:   sc.HostAllowedNames,
+	}
 }
 
 func getEnv(envVar string, defValue string) string {
@@ -38,3 +193,90 @@ func getEnv(envVar string, defValue string) string {
 	}
 	return envValue
 }
+
+func getEnvDuration(envVar string, defValue time.Duration, errs *envErrors) time.Duration {
+	envValue := os.Getenv(envVar)
+	if envValue == "" {
+		return defValue
+	}
+	duration, err := time.ParseDuration(envValue)
+	if err != nil {
+		errs.add(envVar, err)
+		return defValue
+	}
+	return duration
+}
+
+func getEnvList(envVar string, defValue []string) []string {
+	envValue := os.Getenv(envVar)
+	if envValue == "" {
+		return defValue
+	}
+	var values []string
+	for _, value := range strings.Split(envValue, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvInt(envVar string, defValue int, errs *envErrors) int {
+	envValue := os.Getenv(envVar)
+	if envValue == "" {
+		return defValue
+	}
+	value, err := strconv.Atoi(envValue)
+	if err != nil {
+		errs.add(envVar, err)
+		return defValue
+	}
+	return value
+}
+
+func getEnvBool(envVar string, defValue bool, errs *envErrors) bool {
+	envValue := os.Getenv(envVar)
+	if envValue == "" {
+		return defValue
+	}
+	value, err := strconv.ParseBool(envValue)
+	if err != nil {
+		errs.add(envVar, err)
+		return defValue
+	}
+	return value
+}
+
+// envErrors collects every malformed setting so a cold start reports them all at once
+type envErrors []string
+
+func (e *envErrors) add(envVar string, err error) {
+	*e = append(*e, fmt.Sprintf("%s: %s", envVar, err))
+}
+
+func (e *envErrors) err() error {
+	if len(*e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("malformed configuration: %s", strings.Join(*e, "; "))
+}
+
+// getEnvDurationMap reads "name=duration" pairs separated by commas
+func getEnvDurationMap(envVar string, errs *envErrors) map[string]time.Duration {
+	values := map[string]time.Duration{}
+	for _, pair := range getEnvList(envVar, nil) {
+		name, rawDuration, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			errs.add(envVar, fmt.Errorf("%q is not a name=duration pair", pair))
+			continue
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(rawDuration))
+		if err != nil {
+			errs.add(envVar, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		values[name] = duration
+	}
+	return values
+}
@@ -3,33 +3,125 @@ package cloud_test
 import (
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"code.agarg.me/schism/lambda-function/internal/cloud"
+	"code.agarg.me/schism/lambda-function/internal/crypto"
 )
 
 type fields struct {
-	CaSsmKmsKeyId       string
-	CaParamPrefix       string
-	CertsS3Bucket       string
-	CertsS3Prefix       string
-	HostCertsAuthDomain string
+	CaKeyAlgorithm            string
+	CaSsmKmsKeyId             string
+	CaParamPrefix             string
+	CertBackdate              time.Duration
+	CertsS3Bucket             string
+	CertsS3Prefix             string
+	HostCertsAuthDomain       string
+	CaBackend                 string
+	KmsHostCaKeyId            string
+	KmsUserCaKeyId            string
+	KeyAllowedAlgorithms      []string
+	KeyMinRSABits             int
+	KeyAllowedECDSACurves     []string
+	UserKeyRequireSecurityKey bool
+	PrincipalRulesSource      string
+	PolicyCacheTTL            time.Duration
+	HostCertDefaultTTL        time.Duration
+	HostCertMaxTTL            time.Duration
+	UserCertDefaultTTL        time.Duration
+	UserCertMaxTTL            time.Duration
+	PrincipalMaxTTLs          map[string]time.Duration
+	TTLMode                   string
+	SigningProfilesSource     string
+	KeyIDTemplate             string
+	RequireProofOfPossession  bool
+	ChallengeTTL              time.Duration
+	HostAllowedDomains        []string
+	HostAllowedCIDRs          []string
+	HostAllowedNames          []string
+	CaNamespacesSource        string
+	GeneratedKeyKmsKeyId      string
+	RenewalGracePeriod        time.Duration
+	CertBackend               string
+	CertMaxStartDelay         time.Duration
 }
 
 var (
 	defaults = fields{
-		CaSsmKmsKeyId:       "",
-		CaParamPrefix:       cloud.CaParamPrefixDefault,
-		CertsS3Bucket:       cloud.CertsS3BucketDefault,
-		CertsS3Prefix:       "",
-		HostCertsAuthDomain: "",
+		CaKeyAlgorithm:            cloud.CaKeyAlgorithmDefault,
+		CaSsmKmsKeyId:             "",
+		CaParamPrefix:             cloud.CaParamPrefixDefault,
+		CertBackdate:              cloud.CertBackdateDefault,
+		CertsS3Bucket:             cloud.CertsS3BucketDefault,
+		CertsS3Prefix:             "",
+		HostCertsAuthDomain:       "",
+		CaBackend:                 cloud.CaBackendDefault,
+		KmsHostCaKeyId:            "",
+		KmsUserCaKeyId:            "",
+		KeyAllowedAlgorithms:      crypto.DefaultAllowedKeyAlgorithms,
+		KeyMinRSABits:             crypto.DefaultMinRSABits,
+		KeyAllowedECDSACurves:     crypto.DefaultAllowedECDSACurves,
+		UserKeyRequireSecurityKey: false,
+		PrincipalRulesSource:      "",
+		PolicyCacheTTL:            cloud.PolicyCacheTTLDefault,
+		HostCertDefaultTTL:        cloud.HostCertDefaultTTLDefault,
+		HostCertMaxTTL:            cloud.HostCertMaxTTLDefault,
+		UserCertDefaultTTL:        cloud.UserCertDefaultTTLDefault,
+		UserCertMaxTTL:            cloud.UserCertMaxTTLDefault,
+		PrincipalMaxTTLs:          map[string]time.Duration{},
+		TTLMode:                   cloud.TTLModeDefault,
+		SigningProfilesSource:     "",
+		KeyIDTemplate:             crypto.DefaultKeyIDTemplate,
+		RequireProofOfPossession:  false,
+		ChallengeTTL:              cloud.ChallengeTTLDefault,
+		HostAllowedDomains:        nil,
+		HostAllowedCIDRs:          nil,
+		HostAllowedNames:          nil,
+		CaNamespacesSource:        "",
+		GeneratedKeyKmsKeyId:      "",
+		RenewalGracePeriod:        cloud.RenewalGracePeriodDefault,
+		CertBackend:               cloud.CertBackendDefault,
+		CertMaxStartDelay:         cloud.CertMaxStartDelayDefault,
 	}
 	customEnvSet = fields{
-		CaSsmKmsKeyId:       "test-key",
-		CaParamPrefix:       "param-prefix",
-		CertsS3Bucket:       "buckety-mc-bucketface",
-		CertsS3Prefix:       "schism-certs/",
-		HostCertsAuthDomain: "test.example.com",
+		CaKeyAlgorithm:            "ecdsa-p384",
+		CaSsmKmsKeyId:             "test-key",
+		CaParamPrefix:             "param-prefix",
+		CertBackdate:              30 * time.Second,
+		CertsS3Bucket:             "buckety-mc-bucketface",
+		CertsS3Prefix:             "schism-certs/",
+		HostCertsAuthDomain:       "test.example.com",
+		CaBackend:                 cloud.CaBackendKMS,
+		KmsHostCaKeyId:            "alias/schism-host-ca",
+		KmsUserCaKeyId:            "alias/schism-user-ca",
+		KeyAllowedAlgorithms:      []string{"ssh-ed25519", "sk-ssh-ed25519@openssh.com"},
+		KeyMinRSABits:             4096,
+		KeyAllowedECDSACurves:     []string{"nistp256"},
+		UserKeyRequireSecurityKey: true,
+		PrincipalRulesSource:      "s3://schism-policy/principal-rules.yaml",
+		PolicyCacheTTL:            30 * time.Second,
+		HostCertDefaultTTL:        7 * 24 * time.Hour,
+		HostCertMaxTTL:            90 * 24 * time.Hour,
+		UserCertDefaultTTL:        time.Hour,
+		UserCertMaxTTL:            12 * time.Hour,
+		PrincipalMaxTTLs:          map[string]time.Duration{"root": time.Hour, "admin": 4 * time.Hour},
+		TTLMode:                   crypto.TTLModeReject,
+		SigningProfilesSource:     "ssm:schism-signing-profiles",
+		KeyIDTemplate:             "{identity} serial={serial} req={request_id}",
+		RequireProofOfPossession:  true,
+		ChallengeTTL:              time.Minute,
+		HostAllowedDomains:        []string{"example.com", ".internal.example.net"},
+		HostAllowedCIDRs:          []string{"10.0.0.0/8"},
+		HostAllowedNames:          []string{"bastion.example.org"},
+		CaNamespacesSource:        "s3://schism-config/namespaces.yaml",
+		GeneratedKeyKmsKeyId:      "alias/schism-generated-keys",
+		RenewalGracePeriod:        time.Hour,
+		CertBackend:               cloud.CertBackendS3,
+		CertMaxStartDelay:         72 * time.Hour,
 	}
 )
 
@@ -53,22 +145,159 @@ func TestSchismConfig_LoadEnvOrDefault(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			want := &cloud.SchismConfig{
-				CaSsmKmsKeyId:       tt.wants.CaSsmKmsKeyId,
-				CaParamPrefix:       tt.wants.CaParamPrefix,
-				CertsS3Bucket:       tt.wants.CertsS3Bucket,
-				CertsS3Prefix:       tt.wants.CertsS3Prefix,
-				HostCertsAuthDomain: tt.wants.HostCertsAuthDomain,
+				CaKeyAlgorithm:            tt.wants.CaKeyAlgorithm,
+				CaSsmKmsKeyId:             tt.wants.CaSsmKmsKeyId,
+				CaParamPrefix:             tt.wants.CaParamPrefix,
+				CertBackdate:              tt.wants.CertBackdate,
+				CertsS3Bucket:             tt.wants.CertsS3Bucket,
+				CertsS3Prefix:             tt.wants.CertsS3Prefix,
+				HostCertsAuthDomain:       tt.wants.HostCertsAuthDomain,
+				CaBackend:                 tt.wants.CaBackend,
+				KmsHostCaKeyId:            tt.wants.KmsHostCaKeyId,
+				KmsUserCaKeyId:            tt.wants.KmsUserCaKeyId,
+				KeyAllowedAlgorithms:      tt.wants.KeyAllowedAlgorithms,
+				KeyMinRSABits:             tt.wants.KeyMinRSABits,
+				KeyAllowedECDSACurves:     tt.wants.KeyAllowedECDSACurves,
+				UserKeyRequireSecurityKey: tt.wants.UserKeyRequireSecurityKey,
+				PrincipalRulesSource:      tt.wants.PrincipalRulesSource,
+				PolicyCacheTTL:            tt.wants.PolicyCacheTTL,
+				HostCertDefaultTTL:        tt.wants.HostCertDefaultTTL,
+				HostCertMaxTTL:            tt.wants.HostCertMaxTTL,
+				UserCertDefaultTTL:        tt.wants.UserCertDefaultTTL,
+				UserCertMaxTTL:            tt.wants.UserCertMaxTTL,
+				PrincipalMaxTTLs:          tt.wants.PrincipalMaxTTLs,
+				TTLMode:                   tt.wants.TTLMode,
+				SigningProfilesSource:     tt.wants.SigningProfilesSource,
+				KeyIDTemplate:             tt.wants.KeyIDTemplate,
+				RequireProofOfPossession:  tt.wants.RequireProofOfPossession,
+				ChallengeTTL:              tt.wants.ChallengeTTL,
+				HostAllowedDomains:        tt.wants.HostAllowedDomains,
+				HostAllowedCIDRs:          tt.wants.HostAllowedCIDRs,
+				HostAllowedNames:          tt.wants.HostAllowedNames,
+				CaNamespacesSource:        tt.wants.CaNamespacesSource,
+				GeneratedKeyKmsKeyId:      tt.wants.GeneratedKeyKmsKeyId,
+				RenewalGracePeriod:        tt.wants.RenewalGracePeriod,
+				CertBackend:               tt.wants.CertBackend,
+				CertMaxStartDelay:         tt.wants.CertMaxStartDelay,
 			}
 			got := &cloud.SchismConfig{}
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CaKeyAlgorithmEnvVar, tt.env.CaKeyAlgorithm))
 			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CaSsmKmsKeyIdEnvVar, tt.env.CaSsmKmsKeyId))
 			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CaParamPrefixEnvVar, tt.env.CaParamPrefix))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CertBackdateEnvVar, durationEnv(tt.env.CertBackdate)))
 			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CertsS3BucketEnvVar, tt.env.CertsS3Bucket))
 			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CertsS3PrefixEnvVar, tt.env.CertsS3Prefix))
 			cloud.HelperMustSetEnv(t, os.Setenv(cloud.HostCertsAuthDomainEnvVar, tt.env.HostCertsAuthDomain))
-			got.LoadEnv()
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CaBackendEnvVar, tt.env.CaBackend))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.KmsHostCaKeyIdEnvVar, tt.env.KmsHostCaKeyId))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.KmsUserCaKeyIdEnvVar, tt.env.KmsUserCaKeyId))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.KeyAllowedAlgorithmsEnvVar, strings.Join(tt.env.KeyAllowedAlgorithms, ",")))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.KeyMinRSABitsEnvVar, intEnv(tt.env.KeyMinRSABits)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.KeyAllowedECDSACurvesEnvVar, strings.Join(tt.env.KeyAllowedECDSACurves, ",")))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.UserKeyRequireSecurityKeyEnvVar, strconv.FormatBool(tt.env.UserKeyRequireSecurityKey)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.PrincipalRulesSourceEnvVar, tt.env.PrincipalRulesSource))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.PolicyCacheTTLEnvVar, durationEnv(tt.env.PolicyCacheTTL)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.HostCertDefaultTTLEnvVar, durationEnv(tt.env.HostCertDefaultTTL)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.HostCertMaxTTLEnvVar, durationEnv(tt.env.HostCertMaxTTL)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.UserCertDefaultTTLEnvVar, durationEnv(tt.env.UserCertDefaultTTL)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.UserCertMaxTTLEnvVar, durationEnv(tt.env.UserCertMaxTTL)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.PrincipalMaxTTLsEnvVar, durationMapEnv(tt.env.PrincipalMaxTTLs)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.TTLModeEnvVar, tt.env.TTLMode))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.SigningProfilesSourceEnvVar, tt.env.SigningProfilesSource))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.KeyIDTemplateEnvVar, tt.env.KeyIDTemplate))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.RequireProofOfPossessionEnvVar, strconv.FormatBool(tt.env.RequireProofOfPossession)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.ChallengeTTLEnvVar, durationEnv(tt.env.ChallengeTTL)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.HostAllowedDomainsEnvVar, strings.Join(tt.env.HostAllowedDomains, ",")))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.HostAllowedCIDRsEnvVar, strings.Join(tt.env.HostAllowedCIDRs, ",")))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.HostAllowedNamesEnvVar, strings.Join(tt.env.HostAllowedNames, ",")))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CaNamespacesSourceEnvVar, tt.env.CaNamespacesSource))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.GeneratedKeyKmsKeyIdEnvVar, tt.env.GeneratedKeyKmsKeyId))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.RenewalGracePeriodEnvVar, durationEnv(tt.env.RenewalGracePeriod)))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CertBackendEnvVar, tt.env.CertBackend))
+			cloud.HelperMustSetEnv(t, os.Setenv(cloud.CertMaxStartDelayEnvVar, durationEnv(tt.env.CertMaxStartDelay)))
+			if err := got.LoadEnv(); err != nil {
+				t.Fatalf("LoadEnv() error = %v", err)
+			}
 			if !reflect.DeepEqual(got, want) {
 				t.Errorf("LoadEnv() got = %+v, want %+v", got, want)
 			}
 		})
 	}
 }
+
+func TestSchismConfig_LoadEnvMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		envVar string
+		value  string
+	}{
+		{name: "security key requirement", envVar: cloud.UserKeyRequireSecurityKeyEnvVar, value: "yes"},
+		{name: "proof of possession requirement", envVar: cloud.RequireProofOfPossessionEnvVar, value: "on"},
+		{name: "minimum rsa bits", envVar: cloud.KeyMinRSABitsEnvVar, value: "3k"},
+		{name: "max ttl", envVar: cloud.UserCertMaxTTLEnvVar, value: "1 day"},
+		{name: "cert backdate", envVar: cloud.CertBackdateEnvVar, value: "60"},
+		{name: "cert max start delay", envVar: cloud.CertMaxStartDelayEnvVar, value: "1w"},
+		{name: "principal max ttl", envVar: cloud.PrincipalMaxTTLsEnvVar, value: "admin=4h,root=1 hour"},
+		{name: "principal max ttl without a duration", envVar: cloud.PrincipalMaxTTLsEnvVar, value: "root"},
+		{name: "principal max ttl without a name", envVar: cloud.PrincipalMaxTTLsEnvVar, value: "=1h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.envVar, tt.value)
+			err := (&cloud.SchismConfig{}).LoadEnv()
+			if err == nil || !strings.Contains(err.Error(), tt.envVar) {
+				t.Errorf("LoadEnv() error = %v, want one naming %s", err, tt.envVar)
+			}
+		})
+	}
+}
+
+func durationEnv(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func intEnv(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
+
+func durationMapEnv(m map[string]time.Duration) string {
+	var pairs []string
+	for name, d := range m {
+		pairs = append(pairs, name+"="+d.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func TestSchismConfig_HostPrincipalPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		config cloud.SchismConfig
+		want   []string
+	}{
+		{name: "nothing configured", config: cloud.SchismConfig{}, want: nil},
+		{
+			name:   "falls back to the auth domain",
+			config: cloud.SchismConfig{HostCertsAuthDomain: "example.com"},
+			want:   []string{"example.com"},
+		},
+		{
+			name:   "allowed domains win over the auth domain",
+			config: cloud.SchismConfig{HostCertsAuthDomain: "example.com", HostAllowedDomains: []string{"example.net"}},
+			want:   []string{"example.net"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.HostPrincipalPolicy().AllowedDomains; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HostPrincipalPolicy().AllowedDomains = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,40 +1,225 @@
 package crypto
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
 	"golang.org/x/crypto/ssh"
 )
 
 const CAPrivKeyType = "PRIVATE KEY"
 
+const minCARSABits = 2048
+
+const (
+	CAKeyAlgoED25519   = "ed25519"
+	CAKeyAlgoECDSAP256 = "ecdsa-p256"
+	CAKeyAlgoECDSAP384 = "ecdsa-p384"
+	CAKeyAlgoRSA3072   = "rsa-3072"
+	CAKeyAlgoRSA4096   = "rsa-4096"
+)
+
 type EncodedCaPair struct {
-	PrivateKey    []byte `json:"private_key"`
-	AuthorizedKey []byte `json:"authorized_key"`
-	Fingerprint   string `json:"fingerprint"`
+	PrivateKey         []byte `json:"private_key"`
+	AuthorizedKey      []byte `json:"authorized_key"`
+	Fingerprint        string `json:"fingerprint"`
+	SignatureAlgorithm string `json:"signature_algorithm,omitempty"`
+
+	signer ssh.Signer
+}
+
+// NewSignerCaPair wraps a CA whose private key lives elsewhere (e.g. KMS),
+// it can sign but has nothing to persist.
+func NewSignerCaPair(signer ssh.Signer) *EncodedCaPair {
+	return &EncodedCaPair{
+		AuthorizedKey: ssh.MarshalAuthorizedKey(signer.PublicKey()),
+		Fingerprint:   ssh.FingerprintSHA256(signer.PublicKey()),
+		signer:        signer,
+	}
+}
+
+var (
+	ErrCaPairMissingPrivateKey   = errors.New("CA pair has no private key")
+	ErrCaPairPublicKeyMismatch   = errors.New("CA pair authorized key does not match its private key")
+	ErrCaPairFingerprintMismatch = errors.New("CA pair fingerprint does not match its public key")
+	ErrCaPairSelfTestFailed      = errors.New("CA pair failed its sign and verify self-test")
+	caPairSelfTestMessage        = []byte("schism CA self-test")
+)
+
+// Verify makes sure the stored halves of the pair belong together and that the
+// key can actually sign, so a damaged CA is refused when it's loaded rather
+// than part way through issuing a certificate.
+func (encoded *EncodedCaPair) Verify() error {
+	if encoded.signer == nil && len(encoded.PrivateKey) == 0 {
+		return ErrCaPairMissingPrivateKey
+	}
+	signer, err := encoded.Signer()
+	if err != nil {
+		return err
+	}
+	pubKey, err := LazyParseAuthorizedKey(encoded.AuthorizedKey)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCaPairPublicKeyMismatch, err)
+	}
+	if !bytes.Equal(pubKey.Marshal(), signer.PublicKey().Marshal()) {
+		return ErrCaPairPublicKeyMismatch
+	}
+	if encoded.Fingerprint != ssh.FingerprintSHA256(pubKey) {
+		return ErrCaPairFingerprintMismatch
+	}
+	signature, err := signer.Sign(rand.Reader, caPairSelfTestMessage)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCaPairSelfTestFailed, err)
+	}
+	if err := pubKey.Verify(caPairSelfTestMessage, signature); err != nil {
+		return fmt.Errorf("%w: %s", ErrCaPairSelfTestFailed, err)
+	}
+	return nil
 }
 
 func (encoded *EncodedCaPair) Signer() (ssh.Signer, error) {
+	if encoded.signer != nil {
+		return encoded.signer, nil
+	}
 	rawPrivKey, err := ssh.ParsePrivateKey(encoded.PrivateKey)
 	if err != nil {
 		return nil, err
 	}
-	return rawPrivKey, nil
+	if rawPrivKey.PublicKey().Type() != ssh.KeyAlgoRSA {
+		return rawPrivKey, nil
+	}
+	algoSigner, ok := rawPrivKey.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("rsa CA key does not support rsa-sha2 signatures")
+	}
+	sigAlgo := encoded.SignatureAlgorithm
+	if sigAlgo == "" {
+		sigAlgo = ssh.KeyAlgoRSASHA512
+	}
+	if sigAlgo != ssh.KeyAlgoRSASHA256 && sigAlgo != ssh.KeyAlgoRSASHA512 {
+		return nil, fmt.Errorf("unsupported rsa CA signature algorithm: %s", sigAlgo)
+	}
+	return &rsaSHA2Signer{signer: algoSigner, algorithm: sigAlgo}, nil
+}
+
+// rsaSHA2Signer pins an RSA CA to a single rsa-sha2-* algorithm so certificates
+// never fall back to SHA-1 `ssh-rsa` signatures.
+type rsaSHA2Signer struct {
+	signer    ssh.AlgorithmSigner
+	algorithm string
+}
+
+func (s *rsaSHA2Signer) PublicKey() ssh.PublicKey {
+	return s.signer.PublicKey()
+}
+
+func (s *rsaSHA2Signer) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.signer.SignWithAlgorithm(rand, data, s.algorithm)
+}
+
+func generateCAKey(algorithm string) (crypto.PrivateKey, crypto.PublicKey, error) {
+	switch algorithm {
+	case "", CAKeyAlgoED25519:
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		return privKey, pubKey, err
+	case CAKeyAlgoECDSAP256, CAKeyAlgoECDSAP384:
+		curve := elliptic.P256()
+		if algorithm == CAKeyAlgoECDSAP384 {
+			curve = elliptic.P384()
+		}
+		privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privKey, &privKey.PublicKey, nil
+	case CAKeyAlgoRSA3072, CAKeyAlgoRSA4096:
+		bits := 3072
+		if algorithm == CAKeyAlgoRSA4096 {
+			bits = 4096
+		}
+		privKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privKey, &privKey.PublicKey, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported CA key algorithm: %s", algorithm)
+	}
+}
+
+func CreateCA(algorithm string) (*EncodedCaPair, error) {
+	rawPrivKey, rawPubKey, err := generateCAKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return encodeCaPair(rawPrivKey, rawPubKey)
 }
 
-func CreateCA() *EncodedCaPair {
-	rawPubKey, rawPrivKey, _ := ed25519.GenerateKey(nil)
-	rawPemBytes, _ := x509.MarshalPKCS8PrivateKey(rawPrivKey)
+// ImportCA takes an existing CA private key in OpenSSH, PKCS#1 or PKCS#8
+// format and stores it the same way CreateCA would. passphrase is only needed
+// for encrypted keys.
+func ImportCA(rawKey []byte, passphrase []byte) (*EncodedCaPair, error) {
+	var rawPrivKey interface{}
+	var err error
+	if len(passphrase) > 0 {
+		rawPrivKey, err = ssh.ParseRawPrivateKeyWithPassphrase(rawKey, passphrase)
+	} else {
+		rawPrivKey, err = ssh.ParseRawPrivateKey(rawKey)
+	}
+	var missingPassphrase *ssh.PassphraseMissingError
+	if errors.As(err, &missingPassphrase) {
+		return nil, fmt.Errorf("CA private key is encrypted, a passphrase is required")
+	} else if err != nil {
+		return nil, err
+	}
+	switch privKey := rawPrivKey.(type) {
+	case *ed25519.PrivateKey:
+		return encodeCaPair(*privKey, privKey.Public())
+	case ed25519.PrivateKey:
+		return encodeCaPair(privKey, privKey.Public())
+	case *ecdsa.PrivateKey:
+		return encodeCaPair(privKey, &privKey.PublicKey)
+	case *rsa.PrivateKey:
+		if bits := privKey.N.BitLen(); bits < minCARSABits {
+			return nil, fmt.Errorf("rsa CA keys need at least %d bits, got %d", minCARSABits, bits)
+		}
+		return encodeCaPair(privKey, &privKey.PublicKey)
+	default:
+		return nil, fmt.Errorf("unsupported CA private key type %T", rawPrivKey)
+	}
+}
+
+func encodeCaPair(rawPrivKey crypto.PrivateKey, rawPubKey crypto.PublicKey) (*EncodedCaPair, error) {
+	rawPemBytes, err := x509.MarshalPKCS8PrivateKey(rawPrivKey)
+	if err != nil {
+		return nil, err
+	}
 	pemKey := &pem.Block{
 		Type:  CAPrivKeyType,
 		Bytes: rawPemBytes,
 	}
 
-	publicKey, _ := ssh.NewPublicKey(rawPubKey)
-	return &EncodedCaPair{
+	publicKey, err := ssh.NewPublicKey(rawPubKey)
+	if err != nil {
+		return nil, err
+	}
+	caPair := &EncodedCaPair{
 		PrivateKey:    pem.EncodeToMemory(pemKey),
 		AuthorizedKey: ssh.MarshalAuthorizedKey(publicKey),
 		Fingerprint:   ssh.FingerprintSHA256(publicKey),
 	}
+	if publicKey.Type() == ssh.KeyAlgoRSA {
+		caPair.SignatureAlgorithm = ssh.KeyAlgoRSASHA512
+	}
+	return caPair, nil
 }
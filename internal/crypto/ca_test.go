@@ -1,8 +1,11 @@
 package crypto
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"io"
 	"reflect"
 	"strings"
 	"testing"
@@ -10,8 +13,10 @@ import (
 
 func TestCreateCA(t *testing.T) {
 	tests := []struct {
-		name string
-		want *EncodedCaPair
+		name      string
+		algorithm string
+		want      *EncodedCaPair
+		wantErr   bool
 	}{
 		{
 			name: "privateKey encoded PEM type PRIVATE KEY",
@@ -21,10 +26,39 @@ func TestCreateCA(t *testing.T) {
 			name: fmt.Sprintf("authorizedKey is of type %s", ssh.KeyAlgoED25519),
 			want: &EncodedCaPair{AuthorizedKey: []byte(ssh.KeyAlgoED25519)},
 		},
+		{
+			name:      fmt.Sprintf("%s authorizedKey is of type %s", CAKeyAlgoECDSAP256, ssh.KeyAlgoECDSA256),
+			algorithm: CAKeyAlgoECDSAP256,
+			want:      &EncodedCaPair{AuthorizedKey: []byte(ssh.KeyAlgoECDSA256)},
+		},
+		{
+			name:      fmt.Sprintf("%s authorizedKey is of type %s", CAKeyAlgoECDSAP384, ssh.KeyAlgoECDSA384),
+			algorithm: CAKeyAlgoECDSAP384,
+			want:      &EncodedCaPair{AuthorizedKey: []byte(ssh.KeyAlgoECDSA384)},
+		},
+		{
+			name:      fmt.Sprintf("%s authorizedKey is of type %s", CAKeyAlgoRSA3072, ssh.KeyAlgoRSA),
+			algorithm: CAKeyAlgoRSA3072,
+			want: &EncodedCaPair{
+				AuthorizedKey:      []byte(ssh.KeyAlgoRSA),
+				SignatureAlgorithm: ssh.KeyAlgoRSASHA512,
+			},
+		},
+		{
+			name:      "unknown algorithm fails",
+			algorithm: "dsa-1024",
+			wantErr:   true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := CreateCA()
+			got, err := CreateCA(tt.algorithm)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateCA() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			} else if err != nil {
+				return
+			}
 			if got == nil {
 				t.Errorf("CreateCA() = %v, wanted not nil", got)
 				return
@@ -43,15 +77,22 @@ func TestCreateCA(t *testing.T) {
 					string(got.AuthorizedKey), string(tt.want.AuthorizedKey),
 				)
 			}
+			if got.SignatureAlgorithm != tt.want.SignatureAlgorithm {
+				t.Errorf(
+					"CreateCA().SignatureAlgorithm = %v, want %v",
+					got.SignatureAlgorithm, tt.want.SignatureAlgorithm,
+				)
+			}
 		})
 	}
 }
 
 func TestEncodedCaPair_Signer(t *testing.T) {
 	type fields struct {
-		PrivateKey    []byte
-		AuthorizedKey []byte
-		Fingerprint   string
+		PrivateKey         []byte
+		AuthorizedKey      []byte
+		Fingerprint        string
+		SignatureAlgorithm string
 	}
 	tests := []struct {
 		name    string
@@ -69,9 +110,10 @@ func TestEncodedCaPair_Signer(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			encoded := &EncodedCaPair{
-				PrivateKey:    tt.fields.PrivateKey,
-				AuthorizedKey: tt.fields.AuthorizedKey,
-				Fingerprint:   tt.fields.Fingerprint,
+				PrivateKey:         tt.fields.PrivateKey,
+				AuthorizedKey:      tt.fields.AuthorizedKey,
+				Fingerprint:        tt.fields.Fingerprint,
+				SignatureAlgorithm: tt.fields.SignatureAlgorithm,
 			}
 			got, err := encoded.Signer()
 			if (err != nil) != tt.wantErr {
@@ -84,3 +126,163 @@ func TestEncodedCaPair_Signer(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodedCaPair_Signer_RSA(t *testing.T) {
+	rsaCA, err := CreateCA(CAKeyAlgoRSA3072)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name               string
+		signatureAlgorithm string
+		wantFormat         string
+		wantErr            bool
+	}{
+		{
+			name:       "defaults to rsa-sha2-512",
+			wantFormat: ssh.KeyAlgoRSASHA512,
+		},
+		{
+			name:               "honors rsa-sha2-256",
+			signatureAlgorithm: ssh.KeyAlgoRSASHA256,
+			wantFormat:         ssh.KeyAlgoRSASHA256,
+		},
+		{
+			name:               "refuses SHA-1 ssh-rsa",
+			signatureAlgorithm: ssh.KeyAlgoRSA,
+			wantErr:            true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := *rsaCA
+			encoded.SignatureAlgorithm = tt.signatureAlgorithm
+			signer, err := encoded.Signer()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Signer() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			} else if err != nil {
+				return
+			}
+			sig, err := signer.Sign(rand.Reader, []byte("schism"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sig.Format != tt.wantFormat {
+				t.Errorf("Signer().Sign() format = %v, want %v", sig.Format, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestImportCA(t *testing.T) {
+	type args struct {
+		keyFile    string
+		passphrase string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{name: "openssh ed25519", args: args{keyFile: "import-openssh-ed25519"}},
+		{name: "passphrase protected openssh ecdsa", args: args{keyFile: "import-openssh-ecdsa-encrypted", passphrase: "schism-test"}},
+		{name: "pkcs1 rsa", args: args{keyFile: "import-pkcs1-rsa"}},
+		{name: "pkcs8 ecdsa", args: args{keyFile: "import-pkcs8-ecdsa"}},
+		{name: "missing passphrase", args: args{keyFile: "import-openssh-ecdsa-encrypted"}, wantErr: true},
+		{name: "wrong passphrase", args: args{keyFile: "import-openssh-ecdsa-encrypted", passphrase: "nope"}, wantErr: true},
+		{name: "rsa key too small", args: args{keyFile: "import-openssh-rsa-1024"}, wantErr: true},
+		{name: "public key only", args: args{keyFile: "import-openssh-ed25519.pub"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ImportCA(HelperLoadBytes(t, tt.args.keyFile), []byte(tt.args.passphrase))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ImportCA() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			} else if err != nil {
+				return
+			}
+			wantPubKey, err := LazyParseAuthorizedKey(HelperLoadBytes(t, tt.args.keyFile+".pub"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Fingerprint != ssh.FingerprintSHA256(wantPubKey) {
+				t.Errorf("ImportCA() Fingerprint = %s, want %s", got.Fingerprint, ssh.FingerprintSHA256(wantPubKey))
+			}
+			if !strings.Contains(string(got.PrivateKey), "-BEGIN PRIVATE KEY-") {
+				t.Errorf("ImportCA() PrivateKey is not PKCS#8 PEM")
+			}
+			signer, err := got.Signer()
+			if err != nil {
+				t.Errorf("ImportCA() pair has no usable signer: %s", err)
+				return
+			}
+			if !reflect.DeepEqual(signer.PublicKey().Marshal(), wantPubKey.Marshal()) {
+				t.Errorf("ImportCA() signer public key does not match the original")
+			}
+		})
+	}
+}
+
+func TestEncodedCaPair_Verify(t *testing.T) {
+	valid, _ := CreateCA(CAKeyAlgoED25519)
+	validRSA, _ := CreateCA(CAKeyAlgoRSA3072)
+	other, _ := CreateCA(CAKeyAlgoED25519)
+	otherSigner, _ := other.Signer()
+	tests := []struct {
+		name    string
+		caPair  *EncodedCaPair
+		wantErr error
+	}{
+		{name: "generated ed25519 pair", caPair: valid},
+		{name: "generated rsa pair", caPair: validRSA},
+		{name: "signer only pair", caPair: NewSignerCaPair(otherSigner)},
+		{
+			name:    "missing private key",
+			caPair:  &EncodedCaPair{AuthorizedKey: valid.AuthorizedKey, Fingerprint: valid.Fingerprint},
+			wantErr: ErrCaPairMissingPrivateKey,
+		},
+		{
+			name:    "authorized key from another CA",
+			caPair:  &EncodedCaPair{PrivateKey: valid.PrivateKey, AuthorizedKey: other.AuthorizedKey, Fingerprint: other.Fingerprint},
+			wantErr: ErrCaPairPublicKeyMismatch,
+		},
+		{
+			name:    "unparseable authorized key",
+			caPair:  &EncodedCaPair{PrivateKey: valid.PrivateKey, AuthorizedKey: []byte("nope"), Fingerprint: valid.Fingerprint},
+			wantErr: ErrCaPairPublicKeyMismatch,
+		},
+		{
+			name:    "fingerprint from another CA",
+			caPair:  &EncodedCaPair{PrivateKey: valid.PrivateKey, AuthorizedKey: valid.AuthorizedKey, Fingerprint: other.Fingerprint},
+			wantErr: ErrCaPairFingerprintMismatch,
+		},
+		{
+			name:    "signer that can't produce valid signatures",
+			caPair:  NewSignerCaPair(&brokenSigner{Signer: otherSigner}),
+			wantErr: ErrCaPairSelfTestFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.caPair.Verify()
+			if (err != nil) != (tt.wantErr != nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// brokenSigner signs with a flipped bit, like a KMS key that drifted from its cached public half
+type brokenSigner struct {
+	ssh.Signer
+}
+
+func (s *brokenSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signature, err := s.Signer.Sign(rand, data)
+	if err == nil {
+		signature.Blob[0] ^= 0xff
+	}
+	return signature, err
+}
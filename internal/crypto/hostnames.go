@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// HostPrincipalPolicy constrains the names a host certificate may carry. A
+// principal is allowed if it is one of AllowedNames, an IP inside one of
+// AllowedCIDRs, or a name under one of AllowedDomains. A domain entry with a
+// leading dot only covers subdomains. With nothing configured every name is
+// allowed, otherwise a certificate must name at least one host since sshd
+// accepts a host certificate without principals for any host.
+type HostPrincipalPolicy struct {
+	AllowedDomains []string
+	AllowedCIDRs   []string
+	AllowedNames   []string
+}
+
+var ErrNoHostPrincipals = errors.New("host certificates need at least one principal")
+
+func (p *HostPrincipalPolicy) unconstrained() bool {
+	return len(p.AllowedDomains) == 0 && len(p.AllowedCIDRs) == 0 && len(p.AllowedNames) == 0
+}
+
+func (p *HostPrincipalPolicy) Check(principals []string) error {
+	if p.unconstrained() {
+		return nil
+	}
+	if len(principals) == 0 {
+		return &PolicyError{ErrNoHostPrincipals}
+	}
+	for _, principal := range principals {
+		allowed, err := p.allows(principal)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &PolicyError{fmt.Errorf("host principal %s is outside the allowed names", principal)}
+		}
+	}
+	return nil
+}
+
+func (p *HostPrincipalPolicy) allows(principal string) (bool, error) {
+	name := normalizeHostName(principal)
+	if name == "" || strings.ContainsAny(name, "?!,") {
+		return false, nil
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		for _, cidr := range p.AllowedCIDRs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return false, fmt.Errorf("bad allowed host CIDR %s: %w", cidr, err)
+			}
+			if network.Contains(ip) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	// sshd matches host principals as patterns, so a wildcard is only
+	// acceptable as a whole leftmost label under an allowed domain
+	wildcard := strings.HasPrefix(name, "*.")
+	if wildcard {
+		// keep the dot so "*.example.com" is only ever under example.com, never equal to it
+		name = name[1:]
+	}
+	if strings.Contains(name, "*") || strings.Contains(name, "..") || (!wildcard && strings.HasPrefix(name, ".")) {
+		return false, nil
+	}
+	if !wildcard {
+		for _, allowedName := range p.AllowedNames {
+			if name == normalizeHostName(allowedName) {
+				return true, nil
+			}
+		}
+	}
+	for _, domain := range p.AllowedDomains {
+		domain = normalizeHostName(domain)
+		subdomainsOnly := strings.HasPrefix(domain, ".")
+		domain = strings.TrimPrefix(domain, ".")
+		if domain == "" {
+			continue
+		}
+		if strings.HasSuffix(name, "."+domain) || (!subdomainsOnly && name == domain) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func normalizeHostName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
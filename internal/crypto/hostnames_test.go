@@ -0,0 +1,62 @@
+package crypto_test
+
+import (
+	"testing"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestHostPrincipalPolicy_Check(t *testing.T) {
+	policy := &crypto.HostPrincipalPolicy{
+		AllowedDomains: []string{"example.com", ".internal.example.net"},
+		AllowedCIDRs:   []string{"10.0.0.0/8", "2001:db8::/32"},
+		AllowedNames:   []string{"bastion.example.org"},
+	}
+	tests := []struct {
+		name       string
+		policy     *crypto.HostPrincipalPolicy
+		principals []string
+		wantErr    bool
+	}{
+		{name: "domain itself", policy: policy, principals: []string{"example.com"}},
+		{name: "subdomain", policy: policy, principals: []string{"web-1.prod.example.com"}},
+		{name: "case and trailing dot", policy: policy, principals: []string{"Web-1.Example.COM."}},
+		{name: "suffix without a label boundary", policy: policy, principals: []string{"badexample.com"}, wantErr: true},
+		{name: "subdomains only entry", policy: policy, principals: []string{"db.internal.example.net"}},
+		{name: "subdomains only entry excludes the domain", policy: policy, principals: []string{"internal.example.net"}, wantErr: true},
+		{name: "exact name", policy: policy, principals: []string{"bastion.example.org"}},
+		{name: "sibling of exact name", policy: policy, principals: []string{"other.example.org"}, wantErr: true},
+		{name: "ipv4 in cidr", policy: policy, principals: []string{"10.1.2.3"}},
+		{name: "ipv4 outside cidr", policy: policy, principals: []string{"192.168.1.1"}, wantErr: true},
+		{name: "ipv6 in cidr", policy: policy, principals: []string{"2001:db8::1"}},
+		{name: "unrelated host", policy: policy, principals: []string{"web-1.example.com", "github.com"}, wantErr: true},
+		{name: "wildcard under a domain", policy: policy, principals: []string{"*.example.com"}},
+		{name: "wildcard under a subdomains only entry", policy: policy, principals: []string{"*.internal.example.net"}},
+		{name: "wildcard above a domain", policy: policy, principals: []string{"*.com"}, wantErr: true},
+		{name: "bare wildcard", policy: policy, principals: []string{"*"}, wantErr: true},
+		{name: "wildcard inside a label", policy: policy, principals: []string{"web*.example.com"}, wantErr: true},
+		{name: "wildcard in a later label", policy: policy, principals: []string{"web.*.example.com"}, wantErr: true},
+		{name: "wildcard never matches an exact name", policy: policy, principals: []string{"*.example.org"}, wantErr: true},
+		{name: "other pattern characters", policy: policy, principals: []string{"web?.example.com"}, wantErr: true},
+		{name: "negation", policy: policy, principals: []string{"!evil.example.com"}, wantErr: true},
+		{name: "empty label", policy: policy, principals: []string{"web..example.com"}, wantErr: true},
+		{name: "leading dot", policy: policy, principals: []string{".example.com"}, wantErr: true},
+		{name: "empty principal", policy: policy, principals: []string{""}, wantErr: true},
+		{name: "no principals", policy: policy, principals: []string{}, wantErr: true},
+		{name: "nil principals", policy: policy, principals: nil, wantErr: true},
+		{name: "nothing configured allows everything", policy: &crypto.HostPrincipalPolicy{}, principals: []string{"github.com"}},
+		{
+			name:       "bad cidr",
+			policy:     &crypto.HostPrincipalPolicy{AllowedCIDRs: []string{"10.0.0.0/33"}},
+			principals: []string{"10.0.0.1"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Check(tt.principals); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
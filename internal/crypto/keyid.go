@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KeyIDFieldIdentity  = "identity"
+	KeyIDFieldSerial    = "serial"
+	KeyIDFieldCallerARN = "caller_arn"
+	KeyIDFieldProfile   = "profile"
+	KeyIDFieldTimestamp = "timestamp"
+	KeyIDFieldRequestID = "request_id"
+
+	DefaultKeyIDTemplate = "{" + KeyIDFieldIdentity + "}"
+)
+
+type KeyIDMetadata struct {
+	Identity  string
+	Serial    uint64
+	CallerARN string
+	Profile   string
+	IssuedAt  time.Time
+	RequestID string
+}
+
+func (md KeyIDMetadata) field(name string) (string, bool) {
+	switch name {
+	case KeyIDFieldIdentity:
+		return md.Identity, true
+	case KeyIDFieldSerial:
+		return strconv.FormatUint(md.Serial, 10), true
+	case KeyIDFieldCallerARN:
+		return md.CallerARN, true
+	case KeyIDFieldProfile:
+		return md.Profile, true
+	case KeyIDFieldTimestamp:
+		return md.IssuedAt.UTC().Format(time.RFC3339), true
+	case KeyIDFieldRequestID:
+		return md.RequestID, true
+	}
+	return "", false
+}
+
+// RenderKeyID expands {field} placeholders in template. Substituted values are
+// escaped so the rendered KeyId stays a single sshd log token per field, while
+// the literal template text is kept as is.
+func RenderKeyID(template string, md KeyIDMetadata) (string, error) {
+	if template == "" {
+		template = DefaultKeyIDTemplate
+	}
+	var keyID strings.Builder
+	for rest := template; rest != ""; {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			keyID.WriteString(rest)
+			break
+		}
+		keyID.WriteString(rest[:open])
+		if rest[open] == '}' {
+			return "", fmt.Errorf("unmatched '}' in key id template: %s", template)
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unmatched '{' in key id template: %s", template)
+		}
+		name := rest[open+1 : open+end]
+		value, ok := md.field(name)
+		if !ok {
+			return "", fmt.Errorf("unknown key id template field: %s", name)
+		}
+		if value == "" {
+			value = "-"
+		}
+		keyID.WriteString(EscapeKeyIDValue(value))
+		rest = rest[open+end+1:]
+	}
+	return keyID.String(), nil
+}
+
+func ValidateKeyIDTemplate(template string) error {
+	_, err := RenderKeyID(template, KeyIDMetadata{})
+	return err
+}
+
+// EscapeKeyIDValue percent-encodes whitespace, control characters, quotes,
+// backslashes, '=' and anything outside printable ASCII
+func EscapeKeyIDValue(value string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		if b <= ' ' || b >= 0x7f || strings.IndexByte(`"\%=`, b) >= 0 {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+			continue
+		}
+		escaped.WriteByte(b)
+	}
+	return escaped.String()
+}
@@ -0,0 +1,83 @@
+package crypto_test
+
+import (
+	"testing"
+	"time"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestRenderKeyID(t *testing.T) {
+	metadata := crypto.KeyIDMetadata{
+		Identity:  "alice@corp",
+		Serial:    123,
+		CallerARN: "arn:aws:sts::123456789012:assumed-role/dev/alice",
+		Profile:   "developer",
+		IssuedAt:  time.Date(2022, 6, 1, 12, 0, 0, 0, time.FixedZone("EDT", -4*60*60)),
+		RequestID: "abc",
+	}
+	tests := []struct {
+		name     string
+		template string
+		metadata crypto.KeyIDMetadata
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "empty template is the identity",
+			metadata: metadata,
+			want:     "alice@corp",
+		},
+		{
+			name:     "serial and request id",
+			template: "{identity} serial={serial} req={request_id}",
+			metadata: metadata,
+			want:     "alice@corp serial=123 req=abc",
+		},
+		{
+			name:     "every field",
+			template: "{identity} serial={serial} caller={caller_arn} profile={profile} at={timestamp} req={request_id}",
+			metadata: metadata,
+			want:     "alice@corp serial=123 caller=arn:aws:sts::123456789012:assumed-role/dev/alice profile=developer at=2022-06-01T16:00:00Z req=abc",
+		},
+		{
+			name:     "values are escaped",
+			template: "{identity} profile={profile}",
+			metadata: crypto.KeyIDMetadata{Identity: "alice \"admin\"\n", Profile: "a=b%c\\dé"},
+			want:     "alice%20%22admin%22%0A profile=a%3Db%25c%5Cd%C3%A9",
+		},
+		{
+			name:     "empty values are a dash",
+			template: "{identity} profile={profile}",
+			metadata: crypto.KeyIDMetadata{Identity: "alice@corp"},
+			want:     "alice@corp profile=-",
+		},
+		{
+			name:     "unknown field",
+			template: "{identity} {hostname}",
+			wantErr:  true,
+		},
+		{
+			name:     "unclosed field",
+			template: "{identity",
+			wantErr:  true,
+		},
+		{
+			name:     "stray closing brace",
+			template: "identity}",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := crypto.RenderKeyID(tt.template, tt.metadata)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RenderKeyID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("RenderKeyID() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	DefaultAllowedKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoSKED25519,
+		ssh.KeyAlgoECDSA256,
+		ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoSKECDSA256,
+		ssh.KeyAlgoRSA,
+	}
+	DefaultAllowedECDSACurves = []string{"nistp256", "nistp384", "nistp521"}
+)
+
+const DefaultMinRSABits = 2048
+
+var ecdsaKeyCurves = map[string]string{
+	ssh.KeyAlgoECDSA256:   "nistp256",
+	ssh.KeyAlgoECDSA384:   "nistp384",
+	ssh.KeyAlgoECDSA521:   "nistp521",
+	ssh.KeyAlgoSKECDSA256: "nistp256",
+}
+
+// KeyPolicy decides which subject keys are allowed to be certified at all
+type KeyPolicy struct {
+	AllowedAlgorithms  []string
+	MinRSABits         int
+	AllowedECDSACurves []string
+	RequireSecurityKey bool
+}
+
+func DefaultKeyPolicy() *KeyPolicy {
+	return &KeyPolicy{
+		AllowedAlgorithms:  DefaultAllowedKeyAlgorithms,
+		MinRSABits:         DefaultMinRSABits,
+		AllowedECDSACurves: DefaultAllowedECDSACurves,
+	}
+}
+
+func (p *KeyPolicy) Check(pubKey ssh.PublicKey, certType uint32) error {
+	keyType := pubKey.Type()
+	if !containsString(p.AllowedAlgorithms, keyType) {
+		return fmt.Errorf("subject key type %s is not allowed, use one of: %s",
+			keyType, strings.Join(p.AllowedAlgorithms, ", "))
+	}
+	if certType == ssh.UserCert && p.RequireSecurityKey && !strings.HasPrefix(keyType, "sk-") {
+		return fmt.Errorf("user certificates require a FIDO security key (sk-*), got %s", keyType)
+	}
+	if curve, isECDSA := ecdsaKeyCurves[keyType]; isECDSA && !containsString(p.AllowedECDSACurves, curve) {
+		return fmt.Errorf("subject key curve %s is not allowed, use one of: %s",
+			curve, strings.Join(p.AllowedECDSACurves, ", "))
+	}
+	if keyType == ssh.KeyAlgoRSA {
+		cryptoKey, ok := pubKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return fmt.Errorf("unable to inspect the subject %s key", keyType)
+		}
+		rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("unable to inspect the subject %s key", keyType)
+		}
+		if bits := rsaKey.N.BitLen(); bits < p.MinRSABits {
+			return fmt.Errorf("subject rsa key is %d bits, at least %d are required", bits, p.MinRSABits)
+		}
+	}
+	return nil
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package crypto_test
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestKeyPolicy_Check(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *crypto.KeyPolicy
+		keyFile  string
+		certType uint32
+		wantErr  bool
+	}{
+		{
+			name:     "ed25519 passes the default policy",
+			policy:   crypto.DefaultKeyPolicy(),
+			keyFile:  "ed25519-key.pub",
+			certType: ssh.UserCert,
+		},
+		{
+			name:     "dsa is rejected by the default policy",
+			policy:   crypto.DefaultKeyPolicy(),
+			keyFile:  "dsa-key.pub",
+			certType: ssh.UserCert,
+			wantErr:  true,
+		},
+		{
+			name:     "1024 bit rsa is rejected by the default policy",
+			policy:   crypto.DefaultKeyPolicy(),
+			keyFile:  "rsa-1024-key.pub",
+			certType: ssh.HostCert,
+			wantErr:  true,
+		},
+		{
+			name:     "3072 bit rsa passes the default policy",
+			policy:   crypto.DefaultKeyPolicy(),
+			keyFile:  "rsa-3072-key.pub",
+			certType: ssh.HostCert,
+		},
+		{
+			name:     "3072 bit rsa is rejected with a 4096 bit minimum",
+			policy:   &crypto.KeyPolicy{AllowedAlgorithms: []string{ssh.KeyAlgoRSA}, MinRSABits: 4096},
+			keyFile:  "rsa-3072-key.pub",
+			certType: ssh.HostCert,
+			wantErr:  true,
+		},
+		{
+			name: "disallowed ecdsa curve is rejected",
+			policy: &crypto.KeyPolicy{
+				AllowedAlgorithms:  crypto.DefaultAllowedKeyAlgorithms,
+				AllowedECDSACurves: []string{"nistp256"},
+			},
+			keyFile:  "ecdsa-521-key.pub",
+			certType: ssh.UserCert,
+			wantErr:  true,
+		},
+		{
+			name:     "security keys can be required for user certs",
+			policy:   &crypto.KeyPolicy{AllowedAlgorithms: crypto.DefaultAllowedKeyAlgorithms, RequireSecurityKey: true},
+			keyFile:  "ed25519-key.pub",
+			certType: ssh.UserCert,
+			wantErr:  true,
+		},
+		{
+			name:     "security key passes when required",
+			policy:   &crypto.KeyPolicy{AllowedAlgorithms: crypto.DefaultAllowedKeyAlgorithms, RequireSecurityKey: true},
+			keyFile:  "sk-ed25519-key.pub",
+			certType: ssh.UserCert,
+		},
+		{
+			name:     "security key requirement does not apply to host certs",
+			policy:   &crypto.KeyPolicy{AllowedAlgorithms: crypto.DefaultAllowedKeyAlgorithms, RequireSecurityKey: true},
+			keyFile:  "ed25519-key.pub",
+			certType: ssh.HostCert,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pubKey, err := crypto.LazyParseAuthorizedKey(crypto.HelperLoadBytes(t, tt.keyFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.policy.Check(pubKey, tt.certType); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSign_EnforcesKeyPolicy(t *testing.T) {
+	req := &crypto.SigningReq{
+		PublicKey:  crypto.HelperLoadBytes(t, "dsa-key.pub"),
+		CertType:   ssh.UserCert,
+		Identity:   "legacy",
+		Principals: []string{"legacy"},
+		TTL:        300,
+		KeyPolicy:  crypto.DefaultKeyPolicy(),
+	}
+	if got, err := crypto.Sign(req, testSigner); err == nil {
+		t.Errorf("Sign() = %v, wanted the dsa key to be refused", got)
+	}
+}
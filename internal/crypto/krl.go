@@ -0,0 +1,168 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Binary KRL layout as described in OpenSSH's PROTOCOL.krl
+const (
+	krlMagic         = "SSHKRL\n\x00"
+	krlFormatVersion = 1
+
+	krlSectionCertificates = 1
+	krlSectionExplicitKey  = 2
+
+	krlCertSectionSerialList  = 0x20
+	krlCertSectionSerialRange = 0x21
+	krlCertSectionKeyId       = 0x23
+
+	// shortest run of consecutive serials that is cheaper as a range than a list
+	krlMinSerialRange = 3
+)
+
+type KRL struct {
+	Version      uint64
+	GeneratedAt  time.Time
+	Comment      string
+	Certificates []KRLCertificates
+	RevokedKeys  []ssh.PublicKey
+}
+
+// KRLCertificates revokes certificates issued by CA, a nil CA matches any CA.
+type KRLCertificates struct {
+	CA      ssh.PublicKey
+	Serials []uint64
+	KeyIDs  []string
+}
+
+func (k *KRL) Marshal() []byte {
+	out := &bytes.Buffer{}
+	out.WriteString(krlMagic)
+	writeUint32(out, krlFormatVersion)
+	writeUint64(out, k.Version)
+	writeUint64(out, uint64(k.GeneratedAt.Unix()))
+	writeUint64(out, 0)
+	writeString(out, nil)
+	writeString(out, []byte(k.Comment))
+
+	for _, certs := range k.Certificates {
+		section := certs.marshal()
+		if section == nil {
+			continue
+		}
+		out.WriteByte(krlSectionCertificates)
+		writeString(out, section)
+	}
+	if len(k.RevokedKeys) > 0 {
+		section := &bytes.Buffer{}
+		for _, key := range k.RevokedKeys {
+			writeString(section, key.Marshal())
+		}
+		out.WriteByte(krlSectionExplicitKey)
+		writeString(out, section.Bytes())
+	}
+	return out.Bytes()
+}
+
+var ErrNotAKRL = errors.New("not an OpenSSH KRL")
+
+// ParseKRLVersion reads krl_version from a marshaled KRL's header
+func ParseKRLVersion(data []byte) (uint64, error) {
+	headerLen := len(krlMagic) + 4 + 8
+	if len(data) < headerLen || string(data[:len(krlMagic)]) != krlMagic {
+		return 0, ErrNotAKRL
+	}
+	if formatVersion := binary.BigEndian.Uint32(data[len(krlMagic):]); formatVersion != krlFormatVersion {
+		return 0, fmt.Errorf("%w: format version %d", ErrNotAKRL, formatVersion)
+	}
+	return binary.BigEndian.Uint64(data[len(krlMagic)+4:]), nil
+}
+
+func (c *KRLCertificates) marshal() []byte {
+	singles, ranges := compactSerials(c.Serials)
+	if len(singles) == 0 && len(ranges) == 0 && len(c.KeyIDs) == 0 {
+		return nil
+	}
+	section := &bytes.Buffer{}
+	if c.CA != nil {
+		writeString(section, c.CA.Marshal())
+	} else {
+		writeString(section, nil)
+	}
+	writeString(section, nil)
+
+	if len(singles) > 0 {
+		list := &bytes.Buffer{}
+		for _, serial := range singles {
+			writeUint64(list, serial)
+		}
+		section.WriteByte(krlCertSectionSerialList)
+		writeString(section, list.Bytes())
+	}
+	for _, serialRange := range ranges {
+		rangeBuf := &bytes.Buffer{}
+		writeUint64(rangeBuf, serialRange[0])
+		writeUint64(rangeBuf, serialRange[1])
+		section.WriteByte(krlCertSectionSerialRange)
+		writeString(section, rangeBuf.Bytes())
+	}
+	if len(c.KeyIDs) > 0 {
+		keyIds := &bytes.Buffer{}
+		for _, keyId := range c.KeyIDs {
+			writeString(keyIds, []byte(keyId))
+		}
+		section.WriteByte(krlCertSectionKeyId)
+		writeString(section, keyIds.Bytes())
+	}
+	return section.Bytes()
+}
+
+// compactSerials sorts and de-duplicates serials, folding long consecutive
+// runs into [min, max] ranges. Serial 0 is never valid in a KRL and is dropped.
+func compactSerials(serials []uint64) (singles []uint64, ranges [][2]uint64) {
+	sorted := make([]uint64, 0, len(serials))
+	for _, serial := range serials {
+		if serial != 0 {
+			sorted = append(sorted, serial)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && (sorted[j+1] == sorted[j] || sorted[j+1] == sorted[j]+1) {
+			j++
+		}
+		if sorted[j]-sorted[i]+1 >= krlMinSerialRange {
+			ranges = append(ranges, [2]uint64{sorted[i], sorted[j]})
+		} else {
+			for k := i; k <= j; k++ {
+				if len(singles) == 0 || singles[len(singles)-1] != sorted[k] {
+					singles = append(singles, sorted[k])
+				}
+			}
+		}
+		i = j + 1
+	}
+	return singles, ranges
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func writeString(buf *bytes.Buffer, s []byte) {
+	writeUint32(buf, uint32(len(s)))
+	buf.Write(s)
+}
@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_compactSerials(t *testing.T) {
+	tests := []struct {
+		name        string
+		serials     []uint64
+		wantSingles []uint64
+		wantRanges  [][2]uint64
+	}{
+		{
+			name:        "short runs stay in the list",
+			serials:     []uint64{9, 2, 1},
+			wantSingles: []uint64{1, 2, 9},
+		},
+		{
+			name:        "long runs become ranges",
+			serials:     []uint64{7, 3, 4, 5, 1, 8, 9},
+			wantSingles: []uint64{1},
+			wantRanges:  [][2]uint64{{3, 5}, {7, 9}},
+		},
+		{
+			name:        "duplicates and serial zero are dropped",
+			serials:     []uint64{0, 4, 4, 10, 11, 11, 12},
+			wantSingles: []uint64{4},
+			wantRanges:  [][2]uint64{{10, 12}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSingles, gotRanges := compactSerials(tt.serials)
+			if !reflect.DeepEqual(gotSingles, tt.wantSingles) {
+				t.Errorf("compactSerials() singles = %v, want %v", gotSingles, tt.wantSingles)
+			}
+			if !reflect.DeepEqual(gotRanges, tt.wantRanges) {
+				t.Errorf("compactSerials() ranges = %v, want %v", gotRanges, tt.wantRanges)
+			}
+		})
+	}
+}
+
+func TestKRL_Marshal(t *testing.T) {
+	krl := &KRL{Version: 7, GeneratedAt: time.Unix(1654041600, 0), Comment: "schism"}
+	got := krl.Marshal()
+	if !bytes.HasPrefix(got, []byte(krlMagic)) {
+		t.Errorf("Marshal() = %q, wanted the %q magic", got, krlMagic)
+	}
+	// magic, format version, krl version, date, flags, reserved, comment
+	wantLen := len(krlMagic) + 4 + 8 + 8 + 8 + 4 + 4 + len(krl.Comment)
+	if len(got) != wantLen {
+		t.Errorf("Marshal() of an empty KRL is %d bytes, want %d", len(got), wantLen)
+	}
+}
+
+func TestParseKRLVersion(t *testing.T) {
+	marshaled := (&KRL{Version: 42, GeneratedAt: time.Unix(1654041600, 0)}).Marshal()
+	badFormat := append([]byte{}, marshaled...)
+	badFormat[len(krlMagic)+3] = 2
+	tests := []struct {
+		name    string
+		data    []byte
+		want    uint64
+		wantErr bool
+	}{
+		{name: "marshaled krl", data: marshaled, want: 42},
+		{name: "truncated header", data: marshaled[:12], wantErr: true},
+		{name: "not a krl", data: []byte(`{"version": 42, "revocations": []}`), wantErr: true},
+		{name: "unknown format version", data: badFormat, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKRLVersion(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseKRLVersion() error = %v, wantErr %v", err, tt.wantErr)
+			} else if got != tt.want {
+				t.Errorf("ParseKRLVersion() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKRL_MarshalWithSSHKeygen(t *testing.T) {
+	sshKeygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Skip("ssh-keygen is not available")
+	}
+	ca, _ := CreateCA(CAKeyAlgoED25519)
+	caSigner, _ := ca.Signer()
+	userKey, _ := CreateCA(CAKeyAlgoECDSAP256)
+	userSigner, _ := userKey.Signer()
+
+	dir := t.TempDir()
+	writeCert := func(name string, serial uint64, keyId string) string {
+		cert := &ssh.Certificate{
+			Key: userSigner.PublicKey(), Serial: serial, KeyId: keyId, CertType: ssh.UserCert,
+			ValidPrincipals: []string{"test"}, ValidBefore: ssh.CertTimeInfinity,
+		}
+		if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	explicitKey, _ := CreateCA(CAKeyAlgoED25519)
+	explicitKeyPath := filepath.Join(dir, "explicit.pub")
+	if err := os.WriteFile(explicitKeyPath, explicitKey.AuthorizedKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	explicitPubKey, _, _, _, _ := ssh.ParseAuthorizedKey(explicitKey.AuthorizedKey)
+
+	krl := &KRL{
+		Version:     1,
+		GeneratedAt: time.Now(),
+		Certificates: []KRLCertificates{
+			{CA: caSigner.PublicKey(), Serials: []uint64{2, 10, 11, 12}, KeyIDs: []string{"leaked"}},
+		},
+		RevokedKeys: []ssh.PublicKey{explicitPubKey},
+	}
+	krlPath := filepath.Join(dir, "krl")
+	if err := os.WriteFile(krlPath, krl.Marshal(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		keyPath     string
+		wantRevoked bool
+	}{
+		{name: "listed serial", keyPath: writeCert("listed-cert.pub", 2, "a"), wantRevoked: true},
+		{name: "serial in a range", keyPath: writeCert("ranged-cert.pub", 11, "b"), wantRevoked: true},
+		{name: "key id", keyPath: writeCert("keyid-cert.pub", 99, "leaked"), wantRevoked: true},
+		{name: "explicit key", keyPath: explicitKeyPath, wantRevoked: true},
+		{name: "untouched cert", keyPath: writeCert("ok-cert.pub", 3, "c"), wantRevoked: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _ := exec.Command(sshKeygen, "-Q", "-f", krlPath, tt.keyPath).CombinedOutput()
+			gotRevoked := strings.Contains(string(out), "REVOKED")
+			if !gotRevoked && !strings.Contains(string(out), ": ok") {
+				t.Fatalf("ssh-keygen could not check the KRL: %s", out)
+			}
+			if gotRevoked != tt.wantRevoked {
+				t.Errorf("ssh-keygen -Q reported %q, wantRevoked %v", out, tt.wantRevoked)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto/bcrypt_pbkdf"
+)
+
+// see https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.key
+const (
+	openSSHKeyMagic     = "openssh-key-v1\x00"
+	openSSHPEMType      = "OPENSSH PRIVATE KEY"
+	openSSHCipher       = "aes256-ctr"
+	openSSHKDF          = "bcrypt"
+	openSSHKDFRounds    = 16
+	openSSHKDFSaltBytes = 16
+)
+
+type openSSHKeyFile struct {
+	CipherName   string
+	KdfName      string
+	KdfOpts      string
+	NumKeys      uint32
+	PubKey       []byte
+	PrivKeyBlock []byte
+}
+
+type openSSHKDFOptions struct {
+	Salt   []byte
+	Rounds uint32
+}
+
+// ExportOpenSSH renders the CA private key the way `ssh-keygen` writes it, so
+// it can be used for offline signing with `ssh-keygen -s`. A non-empty
+// passphrase encrypts it with aes256-ctr under a bcrypt_pbkdf derived key.
+func (encoded *EncodedCaPair) ExportOpenSSH(comment string, passphrase []byte) ([]byte, error) {
+	if len(encoded.PrivateKey) == 0 {
+		return nil, ErrCaPairMissingPrivateKey
+	}
+	block, _ := pem.Decode(encoded.PrivateKey)
+	if block == nil || block.Type != CAPrivKeyType {
+		return nil, fmt.Errorf("CA private key is not a PKCS#8 %s PEM block", CAPrivKeyType)
+	}
+	rawPrivKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return marshalOpenSSH(rawPrivKey, comment, passphrase)
+}
+
+func marshalOpenSSH(rawPrivKey interface{}, comment string, passphrase []byte) ([]byte, error) {
+	keyType, keyFields, pubKey, err := openSSHKeyFields(rawPrivKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile := openSSHKeyFile{CipherName: "none", KdfName: "none", NumKeys: 1, PubKey: pubKey.Marshal()}
+	blockSize := 8
+	var stream cipher.Stream
+	if len(passphrase) > 0 {
+		salt := make([]byte, openSSHKDFSaltBytes)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		derived, err := bcrypt_pbkdf.Key(passphrase, salt, openSSHKDFRounds, 32+aes.BlockSize)
+		if err != nil {
+			return nil, err
+		}
+		aesCipher, err := aes.NewCipher(derived[:32])
+		if err != nil {
+			return nil, err
+		}
+		stream = cipher.NewCTR(aesCipher, derived[32:])
+		keyFile.CipherName, keyFile.KdfName = openSSHCipher, openSSHKDF
+		keyFile.KdfOpts = string(ssh.Marshal(openSSHKDFOptions{Salt: salt, Rounds: openSSHKDFRounds}))
+		blockSize = aes.BlockSize
+	}
+
+	checkBytes := make([]byte, 4)
+	if _, err := rand.Read(checkBytes); err != nil {
+		return nil, err
+	}
+	check := binary.BigEndian.Uint32(checkBytes)
+	privKeyBlock := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+	}{check, check, keyType})
+	privKeyBlock = append(privKeyBlock, keyFields...)
+	privKeyBlock = append(privKeyBlock, ssh.Marshal(struct{ Comment string }{comment})...)
+	for i := 1; len(privKeyBlock)%blockSize != 0; i++ {
+		privKeyBlock = append(privKeyBlock, byte(i))
+	}
+	if stream != nil {
+		stream.XORKeyStream(privKeyBlock, privKeyBlock)
+	}
+	keyFile.PrivKeyBlock = privKeyBlock
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  openSSHPEMType,
+		Bytes: append([]byte(openSSHKeyMagic), ssh.Marshal(keyFile)...),
+	}), nil
+}
+
+func openSSHKeyFields(rawPrivKey interface{}) (string, []byte, ssh.PublicKey, error) {
+	switch privKey := rawPrivKey.(type) {
+	case ed25519.PrivateKey:
+		pubKey, err := ssh.NewPublicKey(privKey.Public())
+		if err != nil {
+			return "", nil, nil, err
+		}
+		return ssh.KeyAlgoED25519, ssh.Marshal(struct {
+			Pub  []byte
+			Priv []byte
+		}{[]byte(privKey.Public().(ed25519.PublicKey)), []byte(privKey)}), pubKey, nil
+	case *ecdsa.PrivateKey:
+		pubKey, err := ssh.NewPublicKey(&privKey.PublicKey)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		curve, ok := ecdsaKeyCurves[pubKey.Type()]
+		if !ok {
+			return "", nil, nil, fmt.Errorf("unsupported ecdsa key type %s", pubKey.Type())
+		}
+		return pubKey.Type(), ssh.Marshal(struct {
+			Curve string
+			Pub   []byte
+			D     *big.Int
+		}{curve, elliptic.Marshal(privKey.Curve, privKey.X, privKey.Y), privKey.D}), pubKey, nil
+	case *rsa.PrivateKey:
+		if len(privKey.Primes) != 2 {
+			return "", nil, nil, fmt.Errorf("multi-prime rsa keys can't be exported")
+		}
+		pubKey, err := ssh.NewPublicKey(&privKey.PublicKey)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		privKey.Precompute()
+		return ssh.KeyAlgoRSA, ssh.Marshal(struct {
+			N    *big.Int
+			E    *big.Int
+			D    *big.Int
+			Iqmp *big.Int
+			P    *big.Int
+			Q    *big.Int
+		}{
+			privKey.N, big.NewInt(int64(privKey.E)), privKey.D,
+			privKey.Precomputed.Qinv, privKey.Primes[0], privKey.Primes[1],
+		}), pubKey, nil
+	default:
+		return "", nil, nil, fmt.Errorf("unsupported private key type %T", rawPrivKey)
+	}
+}
+
+// Client key algorithms GenerateKeyPair accepts. They're kept apart from the
+// CA ones, there is no default and the set can change without touching CAs.
+const (
+	ClientKeyAlgoED25519   = "ed25519"
+	ClientKeyAlgoECDSAP256 = "ecdsa-p256"
+	ClientKeyAlgoECDSAP384 = "ecdsa-p384"
+	ClientKeyAlgoECDSAP521 = "ecdsa-p521"
+	ClientKeyAlgoRSA3072   = "rsa-3072"
+	ClientKeyAlgoRSA4096   = "rsa-4096"
+)
+
+func generateClientKey(algorithm string) (crypto.PrivateKey, crypto.PublicKey, error) {
+	switch algorithm {
+	case ClientKeyAlgoED25519:
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		return privKey, pubKey, err
+	case ClientKeyAlgoECDSAP256, ClientKeyAlgoECDSAP384, ClientKeyAlgoECDSAP521:
+		curve := map[string]elliptic.Curve{
+			ClientKeyAlgoECDSAP256: elliptic.P256(),
+			ClientKeyAlgoECDSAP384: elliptic.P384(),
+			ClientKeyAlgoECDSAP521: elliptic.P521(),
+		}[algorithm]
+		privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privKey, &privKey.PublicKey, nil
+	case ClientKeyAlgoRSA3072, ClientKeyAlgoRSA4096:
+		bits := 3072
+		if algorithm == ClientKeyAlgoRSA4096 {
+			bits = 4096
+		}
+		privKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privKey, &privKey.PublicKey, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported client key algorithm: %s", algorithm)
+	}
+}
+
+// GenerateKeyPair makes a throwaway client key pair, the private half in the
+// format ExportOpenSSH writes
+func GenerateKeyPair(algorithm string, comment string) (authorizedKey []byte, privateKey []byte, err error) {
+	rawPrivKey, rawPubKey, err := generateClientKey(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubKey, err := ssh.NewPublicKey(rawPubKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey, err = marshalOpenSSH(rawPrivKey, comment, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ssh.MarshalAuthorizedKey(pubKey), privateKey, nil
+}
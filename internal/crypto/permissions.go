@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	OptionForceCommand   = "force-command"
+	OptionSourceAddress  = "source-address"
+	OptionVerifyRequired = "verify-required"
+
+	ExtensionNoTouchRequired       = "no-touch-required"
+	ExtensionPermitX11Forwarding   = "permit-X11-forwarding"
+	ExtensionPermitAgentForwarding = "permit-agent-forwarding"
+	ExtensionPermitPortForwarding  = "permit-port-forwarding"
+	ExtensionPermitPty             = "permit-pty"
+	ExtensionPermitUserRc          = "permit-user-rc"
+)
+
+var flagExtensions = map[string]bool{
+	ExtensionNoTouchRequired:       true,
+	ExtensionPermitX11Forwarding:   true,
+	ExtensionPermitAgentForwarding: true,
+	ExtensionPermitPortForwarding:  true,
+	ExtensionPermitPty:             true,
+	ExtensionPermitUserRc:          true,
+}
+
+// DefaultExtensions mirrors what `ssh-keygen -s` grants when no options are
+// given: the full set of permit-* flags for user certs and nothing for hosts.
+func DefaultExtensions(certType uint32) map[string]string {
+	if certType != ssh.UserCert {
+		return map[string]string{}
+	}
+	return map[string]string{
+		ExtensionPermitX11Forwarding:   "",
+		ExtensionPermitAgentForwarding: "",
+		ExtensionPermitPortForwarding:  "",
+		ExtensionPermitPty:             "",
+		ExtensionPermitUserRc:          "",
+	}
+}
+
+// CertPermissions validates the requested options and extensions for certType.
+// A nil extensions map falls back to DefaultExtensions, an empty one grants none.
+func CertPermissions(certType uint32, criticalOptions map[string]string, extensions map[string]string) (ssh.Permissions, error) {
+	if extensions == nil {
+		extensions = DefaultExtensions(certType)
+	}
+	if certType == ssh.HostCert {
+		if len(criticalOptions) > 0 || len(extensions) > 0 {
+			return ssh.Permissions{}, fmt.Errorf("host certificates do not support critical options or extensions")
+		}
+		return ssh.Permissions{}, nil
+	}
+	for name, value := range criticalOptions {
+		if err := checkCriticalOption(name, value); err != nil {
+			return ssh.Permissions{}, err
+		}
+	}
+	for name, value := range extensions {
+		if err := checkExtension(name, value); err != nil {
+			return ssh.Permissions{}, err
+		}
+	}
+	return ssh.Permissions{
+		CriticalOptions: copyOrNil(criticalOptions),
+		Extensions:      copyOrNil(extensions),
+	}, nil
+}
+
+func checkCriticalOption(name string, value string) error {
+	switch name {
+	case OptionForceCommand:
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("critical option %s requires a command", name)
+		}
+	case OptionSourceAddress:
+		if value == "" {
+			return fmt.Errorf("critical option %s requires at least one address", name)
+		}
+		for _, addr := range strings.Split(value, ",") {
+			if _, _, err := net.ParseCIDR(addr); err == nil {
+				continue
+			}
+			if net.ParseIP(addr) == nil {
+				return fmt.Errorf("critical option %s has an invalid address: '%s'", name, addr)
+			}
+		}
+	case OptionVerifyRequired:
+		if value != "" {
+			return fmt.Errorf("critical option %s does not take a value", name)
+		}
+	default:
+		return fmt.Errorf("unsupported critical option: %s", name)
+	}
+	return nil
+}
+
+func checkExtension(name string, value string) error {
+	if flagExtensions[name] {
+		if value != "" {
+			return fmt.Errorf("extension %s does not take a value", name)
+		}
+		return nil
+	}
+	// Anything outside the OpenSSH set has to be a vendor extension (name@domain)
+	if at := strings.Index(name, "@"); at < 1 || at == len(name)-1 {
+		return fmt.Errorf("unsupported extension: %s", name)
+	}
+	return nil
+}
+
+func copyOrNil(in map[string]string) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
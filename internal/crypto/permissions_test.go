@@ -0,0 +1,115 @@
+package crypto_test
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestCertPermissions(t *testing.T) {
+	type args struct {
+		certType        uint32
+		criticalOptions map[string]string
+		extensions      map[string]string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    ssh.Permissions
+		wantErr bool
+	}{
+		{
+			name: "user certs get the default extensions",
+			args: args{certType: ssh.UserCert},
+			want: ssh.Permissions{Extensions: crypto.DefaultExtensions(ssh.UserCert)},
+		},
+		{
+			name: "an empty extensions map grants nothing",
+			args: args{certType: ssh.UserCert, extensions: map[string]string{}},
+			want: ssh.Permissions{},
+		},
+		{
+			name: "host certs get no defaults",
+			args: args{certType: ssh.HostCert},
+			want: ssh.Permissions{},
+		},
+		{
+			name: "supported critical options pass through",
+			args: args{
+				certType: ssh.UserCert,
+				criticalOptions: map[string]string{
+					crypto.OptionForceCommand:   "/usr/bin/uptime",
+					crypto.OptionSourceAddress:  "10.0.0.0/8,192.168.1.1",
+					crypto.OptionVerifyRequired: "",
+				},
+				extensions: map[string]string{crypto.ExtensionPermitPty: "", "login@example.com": "alice"},
+			},
+			want: ssh.Permissions{
+				CriticalOptions: map[string]string{
+					crypto.OptionForceCommand:   "/usr/bin/uptime",
+					crypto.OptionSourceAddress:  "10.0.0.0/8,192.168.1.1",
+					crypto.OptionVerifyRequired: "",
+				},
+				Extensions: map[string]string{crypto.ExtensionPermitPty: "", "login@example.com": "alice"},
+			},
+		},
+		{
+			name: "host certs reject critical options",
+			args: args{
+				certType:        ssh.HostCert,
+				criticalOptions: map[string]string{crypto.OptionForceCommand: "/bin/true"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "host certs reject extensions",
+			args:    args{certType: ssh.HostCert, extensions: map[string]string{crypto.ExtensionPermitPty: ""}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown critical options are rejected",
+			args:    args{certType: ssh.UserCert, criticalOptions: map[string]string{"no-such-option": ""}},
+			wantErr: true,
+		},
+		{
+			name:    "malformed source-address is rejected",
+			args:    args{certType: ssh.UserCert, criticalOptions: map[string]string{crypto.OptionSourceAddress: "10.0.0.0/8,nope"}},
+			wantErr: true,
+		},
+		{
+			name:    "empty force-command is rejected",
+			args:    args{certType: ssh.UserCert, criticalOptions: map[string]string{crypto.OptionForceCommand: " "}},
+			wantErr: true,
+		},
+		{
+			name:    "verify-required does not take a value",
+			args:    args{certType: ssh.UserCert, criticalOptions: map[string]string{crypto.OptionVerifyRequired: "yes"}},
+			wantErr: true,
+		},
+		{
+			name:    "flag extensions do not take a value",
+			args:    args{certType: ssh.UserCert, extensions: map[string]string{crypto.ExtensionPermitPty: "yes"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown extensions need a vendor domain",
+			args:    args{certType: ssh.UserCert, extensions: map[string]string{"permit-everything": ""}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := crypto.CertPermissions(tt.args.certType, tt.args.criticalOptions, tt.args.extensions)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CertPermissions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CertPermissions() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
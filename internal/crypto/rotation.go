@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	RotationIdle     = "idle"
+	RotationPending  = "pending"
+	RotationPromoted = "promoted"
+
+	RotationStepGenerate = "generate"
+	RotationStepPromote  = "promote"
+	RotationStepRetire   = "retire"
+)
+
+// CaKeyRing holds every CA key a certificate type currently trusts. Only
+// Current signs, Pending and Previous are published so hosts trust them
+// through the overlap of a rotation.
+type CaKeyRing struct {
+	Current  *EncodedCaPair
+	Pending  *EncodedCaPair
+	Previous *EncodedCaPair
+}
+
+func (ring *CaKeyRing) State() string {
+	switch {
+	case ring.Pending != nil:
+		return RotationPending
+	case ring.Previous != nil:
+		return RotationPromoted
+	default:
+		return RotationIdle
+	}
+}
+
+func (ring *CaKeyRing) Trusted() []*EncodedCaPair {
+	var trusted []*EncodedCaPair
+	for _, caPair := range []*EncodedCaPair{ring.Current, ring.Pending, ring.Previous} {
+		if caPair != nil {
+			trusted = append(trusted, caPair)
+		}
+	}
+	return trusted
+}
+
+func (ring *CaKeyRing) AuthorizedKeys() []byte {
+	var authorizedKeys [][]byte
+	for _, caPair := range ring.Trusted() {
+		authorizedKeys = append(authorizedKeys, bytes.TrimSpace(caPair.AuthorizedKey))
+	}
+	if len(authorizedKeys) == 0 {
+		return nil
+	}
+	return append(bytes.Join(authorizedKeys, []byte("\n")), '\n')
+}
+
+func (ring *CaKeyRing) PublicKeys() ([]ssh.PublicKey, error) {
+	var pubKeys []ssh.PublicKey
+	for _, caPair := range ring.Trusted() {
+		pubKey, err := LazyParseAuthorizedKey(caPair.AuthorizedKey)
+		if err != nil {
+			return nil, err
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	return pubKeys, nil
+}
+
+func (ring *CaKeyRing) Fingerprints() []string {
+	var fingerprints []string
+	for _, caPair := range ring.Trusted() {
+		fingerprints = append(fingerprints, caPair.Fingerprint)
+	}
+	return fingerprints
+}
+
+// Rotate moves the ring one step through idle -> pending -> promoted -> idle.
+func (ring *CaKeyRing) Rotate(step string, algorithm string) error {
+	switch step {
+	case RotationStepGenerate:
+		if ring.State() != RotationIdle {
+			return errors.New("a rotation is already in progress, promote or retire it first")
+		}
+		pending, err := CreateCA(algorithm)
+		if err != nil {
+			return err
+		}
+		ring.Pending = pending
+	case RotationStepPromote:
+		if ring.State() != RotationPending {
+			return errors.New("there is no pending CA to promote")
+		}
+		ring.Previous, ring.Current, ring.Pending = ring.Current, ring.Pending, nil
+	case RotationStepRetire:
+		if ring.State() != RotationPromoted {
+			return errors.New("there is no previous CA to retire")
+		}
+		ring.Previous = nil
+	default:
+		return errors.New("unknown rotation step: " + step)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCaKeyRing_Rotate(t *testing.T) {
+	current, _ := CreateCA(CAKeyAlgoED25519)
+	ring := &CaKeyRing{Current: current}
+
+	steps := []struct {
+		name        string
+		step        string
+		wantState   string
+		wantTrusted int
+		wantErr     bool
+	}{
+		{name: "cannot promote without a pending CA", step: RotationStepPromote, wantState: RotationIdle, wantTrusted: 1, wantErr: true},
+		{name: "cannot retire without a previous CA", step: RotationStepRetire, wantState: RotationIdle, wantTrusted: 1, wantErr: true},
+		{name: "generate a pending CA", step: RotationStepGenerate, wantState: RotationPending, wantTrusted: 2},
+		{name: "cannot generate twice", step: RotationStepGenerate, wantState: RotationPending, wantTrusted: 2, wantErr: true},
+		{name: "promote the pending CA", step: RotationStepPromote, wantState: RotationPromoted, wantTrusted: 2},
+		{name: "cannot generate before retiring", step: RotationStepGenerate, wantState: RotationPromoted, wantTrusted: 2, wantErr: true},
+		{name: "retire the previous CA", step: RotationStepRetire, wantState: RotationIdle, wantTrusted: 1},
+		{name: "unknown step", step: "yolo", wantState: RotationIdle, wantTrusted: 1, wantErr: true},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ring.Rotate(tt.step, CAKeyAlgoED25519)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Rotate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := ring.State(); got != tt.wantState {
+				t.Errorf("State() = %v, want %v", got, tt.wantState)
+			}
+			if got := len(ring.Trusted()); got != tt.wantTrusted {
+				t.Errorf("len(Trusted()) = %v, want %v", got, tt.wantTrusted)
+			}
+		})
+	}
+	if ring.Current == current {
+		t.Errorf("Current is still the original CA after a full rotation")
+	}
+}
+
+func TestCaKeyRing_AuthorizedKeys(t *testing.T) {
+	current, _ := CreateCA(CAKeyAlgoED25519)
+	pending, _ := CreateCA(CAKeyAlgoECDSAP256)
+	ring := &CaKeyRing{Current: current, Pending: pending}
+
+	got := ring.AuthorizedKeys()
+	if lines := bytes.Count(got, []byte("\n")); lines != 2 {
+		t.Errorf("AuthorizedKeys() has %d lines, want 2:\n%s", lines, got)
+	}
+	pubKeys, err := ring.PublicKeys()
+	if err != nil || len(pubKeys) != 2 {
+		t.Errorf("PublicKeys() = %v, err = %v, want 2 keys", pubKeys, err)
+	}
+	if got := (&CaKeyRing{}).AuthorizedKeys(); got != nil {
+		t.Errorf("AuthorizedKeys() of an empty ring = %q, want nil", got)
+	}
+}
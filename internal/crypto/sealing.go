@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/ssh"
+)
+
+// SealSchemeX25519 seals to the X25519 form of the requester's ed25519 key: an
+// ephemeral X25519 exchange feeds HKDF-SHA256 (salt is ephemeral || recipient
+// key), whose output keys ChaCha20-Poly1305 with an all zero nonce. The key is
+// never reused, so the fixed nonce is safe.
+const SealSchemeX25519 = "x25519-hkdf-sha256-chacha20poly1305"
+
+const sealInfo = "schism-signed-certificate"
+
+var ErrSealUnsupportedKey = errors.New("sealing needs an ssh-ed25519 key")
+
+// curve25519P is 2^255 - 19
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+type SealedBox struct {
+	Scheme               string `json:"scheme"`
+	EphemeralKey         []byte `json:"ephemeral_key"`
+	RecipientFingerprint string `json:"recipient_fingerprint"`
+}
+
+// Seal encrypts plaintext so only the holder of recipient's private key can
+// read it, the box describes how to open the returned ciphertext
+func Seal(recipient ssh.PublicKey, plaintext []byte) (*SealedBox, []byte, error) {
+	recipientKey, err := x25519PublicKey(recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	ephemeralPriv := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeralPriv); err != nil {
+		return nil, nil, err
+	}
+	ephemeralKey, err := curve25519.X25519(ephemeralPriv, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := curve25519.X25519(ephemeralPriv, recipientKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := sealAEAD(shared, ephemeralKey, recipientKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	box := &SealedBox{
+		Scheme:               SealSchemeX25519,
+		EphemeralKey:         ephemeralKey,
+		RecipientFingerprint: ssh.FingerprintSHA256(recipient),
+	}
+	return box, aead.Seal(nil, make([]byte, aead.NonceSize()), plaintext, nil), nil
+}
+
+// Open is the client half of Seal
+func (box *SealedBox) Open(recipient ed25519.PrivateKey, ciphertext []byte) ([]byte, error) {
+	if box.Scheme != SealSchemeX25519 {
+		return nil, fmt.Errorf("unsupported sealing scheme: %s", box.Scheme)
+	}
+	digest := sha512.Sum512(recipient.Seed())
+	recipientKey, err := x25519PublicKeyFromEd25519(recipient.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	// X25519 clamps the scalar itself
+	shared, err := curve25519.X25519(digest[:curve25519.ScalarSize], box.EphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := sealAEAD(shared, box.EphemeralKey, recipientKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), ciphertext, nil)
+}
+
+func sealAEAD(shared []byte, ephemeralKey []byte, recipientKey []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeralKey...), recipientKey...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(sealInfo)), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+func x25519PublicKey(pubKey ssh.PublicKey) ([]byte, error) {
+	cryptoPubKey, ok := pubKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, ErrSealUnsupportedKey
+	}
+	edPubKey, ok := cryptoPubKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrSealUnsupportedKey
+	}
+	return x25519PublicKeyFromEd25519(edPubKey)
+}
+
+// x25519PublicKeyFromEd25519 maps the Edwards y coordinate to the Montgomery
+// u = (1 + y) / (1 - y), see RFC 7748 section 4.1
+func x25519PublicKeyFromEd25519(edPubKey ed25519.PublicKey) ([]byte, error) {
+	if len(edPubKey) != ed25519.PublicKeySize {
+		return nil, ErrSealUnsupportedKey
+	}
+	littleEndian := append([]byte{}, edPubKey...)
+	littleEndian[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverseBytes(littleEndian))
+	denominator := new(big.Int).Sub(big.NewInt(1), y)
+	denominator.Mod(denominator, curve25519P)
+	if denominator.Sign() == 0 {
+		return nil, fmt.Errorf("ed25519 key has no X25519 equivalent")
+	}
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, denominator.ModInverse(denominator, curve25519P))
+	u.Mod(u, curve25519P)
+	uBytes := make([]byte, curve25519.PointSize)
+	return reverseBytes(u.FillBytes(uBytes)), nil
+}
+
+func reverseBytes(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
@@ -0,0 +1,67 @@
+package crypto_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestSeal(t *testing.T) {
+	recipientPub, recipientPriv, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	recipient, _ := ssh.NewPublicKey(recipientPub)
+	rsaKey, _ := crypto.LazyParseAuthorizedKey(crypto.HelperLoadBytes(t, "pop-rsa-key.pub"))
+	plaintext := []byte("ssh-ed25519-cert-v01@openssh.com AAAA... alice")
+
+	tests := []struct {
+		name    string
+		tamper  func(box *crypto.SealedBox, ciphertext []byte)
+		opener  ed25519.PrivateKey
+		wantErr bool
+	}{
+		{name: "recipient can open the box", opener: recipientPriv},
+		{name: "anyone else can't", opener: otherPriv, wantErr: true},
+		{
+			name:    "tampered ciphertext is rejected",
+			tamper:  func(box *crypto.SealedBox, ciphertext []byte) { ciphertext[0] ^= 1 },
+			opener:  recipientPriv,
+			wantErr: true,
+		},
+		{
+			name:    "unknown scheme is rejected",
+			tamper:  func(box *crypto.SealedBox, ciphertext []byte) { box.Scheme = "rot13" },
+			opener:  recipientPriv,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			box, ciphertext, err := crypto.Seal(recipient, plaintext)
+			if err != nil {
+				t.Fatalf("Seal() error = %v", err)
+			}
+			if box.Scheme != crypto.SealSchemeX25519 || box.RecipientFingerprint != ssh.FingerprintSHA256(recipient) {
+				t.Errorf("Seal() got = %+v", box)
+			}
+			if tt.tamper != nil {
+				tt.tamper(box, ciphertext)
+			}
+			got, err := box.Open(tt.opener, ciphertext)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Open() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && string(got) != string(plaintext) {
+				t.Errorf("Open() got = %q, want %q", got, plaintext)
+			}
+		})
+	}
+	if _, _, err := crypto.Seal(rsaKey, plaintext); !errors.Is(err, crypto.ErrSealUnsupportedKey) {
+		t.Errorf("Seal() to an rsa key error = %v, want %v", err, crypto.ErrSealUnsupportedKey)
+	}
+}
@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+type SerialAllocator interface {
+	AllocateSerial(lookupKey string) (uint64, error)
+}
+
+type randomSerialAllocator struct{}
+
+func (randomSerialAllocator) AllocateSerial(_ string) (uint64, error) {
+	return certSerial()
+}
+
+func certSerial() (uint64, error) {
+	buff := make([]byte, 8)
+	if _, err := rand.Read(buff); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buff), nil
+}
+
+type MemorySerialAllocator struct {
+	mu     sync.Mutex
+	next   uint64
+	issued map[uint64]string
+}
+
+func NewMemorySerialAllocator(first uint64) *MemorySerialAllocator {
+	if first == 0 {
+		first = 1
+	}
+	return &MemorySerialAllocator{next: first, issued: map[uint64]string{}}
+}
+
+func (m *MemorySerialAllocator) AllocateSerial(lookupKey string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, taken := m.issued[m.next]; taken || m.next == 0 {
+		return 0, fmt.Errorf("serial %d is already allocated", m.next)
+	}
+	serial := m.next
+	m.issued[serial] = lookupKey
+	m.next++
+	return serial, nil
+}
+
+func (m *MemorySerialAllocator) LookupKey(serial uint64) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lookupKey, ok := m.issued[serial]
+	return lookupKey, ok
+}
@@ -0,0 +1,73 @@
+package crypto_test
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestMemorySerialAllocator_AllocateSerial(t *testing.T) {
+	tests := []struct {
+		name       string
+		first      uint64
+		lookupKeys []string
+		want       []uint64
+	}{
+		{
+			name:       "serials start at one by default",
+			first:      0,
+			lookupKeys: []string{"user:a", "user:b", "host:c"},
+			want:       []uint64{1, 2, 3},
+		},
+		{
+			name:       "serials continue from the given start",
+			first:      41,
+			lookupKeys: []string{"user:a", "user:a"},
+			want:       []uint64{41, 42},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocator := crypto.NewMemorySerialAllocator(tt.first)
+			for i, lookupKey := range tt.lookupKeys {
+				got, err := allocator.AllocateSerial(lookupKey)
+				if err != nil {
+					t.Fatalf("AllocateSerial() error = %v", err)
+				}
+				if got != tt.want[i] {
+					t.Errorf("AllocateSerial() got = %v, want %v", got, tt.want[i])
+				}
+				if recorded, ok := allocator.LookupKey(got); !ok || recorded != lookupKey {
+					t.Errorf("LookupKey(%d) got = '%v', want '%v'", got, recorded, lookupKey)
+				}
+			}
+		})
+	}
+}
+
+func TestSign_UsesSerialAllocator(t *testing.T) {
+	allocator := crypto.NewMemorySerialAllocator(100)
+	req := &crypto.SigningReq{
+		PublicKey:  crypto.HelperLoadBytes(t, "ed25519-key.pub"),
+		CertType:   ssh.HostCert,
+		Identity:   "test.example.com",
+		Principals: []string{"test.example.com"},
+		TTL:        300,
+		LookupKey:  "host:test",
+		Serials:    allocator,
+	}
+	for _, want := range []uint64{100, 101} {
+		got, err := crypto.Sign(req, testSigner)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		if got.Serial != want {
+			t.Errorf("Sign() got.Serial = %v, want %v", got.Serial, want)
+		}
+	}
+	if lookupKey, _ := allocator.LookupKey(101); lookupKey != req.LookupKey {
+		t.Errorf("LookupKey(101) got = '%v', want '%v'", lookupKey, req.LookupKey)
+	}
+}
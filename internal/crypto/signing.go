@@ -2,45 +2,152 @@ package crypto
 
 import (
 	"crypto/rand"
-	"encoding/binary"
+	"fmt"
 	"golang.org/x/crypto/ssh"
 	"time"
 )
 
+// PolicyError is a request Sign refused to certify, anything else it returns
+// is a failure to sign
+type PolicyError struct {
+	Err error
+}
+
+func (e *PolicyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
 type SigningReq struct {
 	PublicKey  []byte
 	CertType   uint32
 	Identity   string
 	Principals []string
 	TTL        time.Duration
+
+	CriticalOptions map[string]string
+	Extensions      map[string]string
+
+	StartsAt time.Time
+	Backdate time.Duration
+	// MaxStartDelay bounds how far ahead StartsAt may be, zero leaves it unbounded
+	MaxStartDelay time.Duration
+	Clock         func() time.Time
+
+	LookupKey string
+	Serials   SerialAllocator
+
+	KeyPolicy           *KeyPolicy
+	TTLPolicy           *TTLPolicy
+	HostPrincipalPolicy *HostPrincipalPolicy
+
+	KeyIDTemplate string
+	CallerARN     string
+	Profile       string
+	RequestID     string
 }
 
-var oneMinAgo = uint64(time.Now().Add(-time.Minute).Unix())
+func (req *SigningReq) now() time.Time {
+	if req.Clock != nil {
+		return req.Clock()
+	}
+	return time.Now()
+}
 
-func certSerial() uint64 {
-	buff := make([]byte, 8)
-	_, _ = rand.Read(buff)
-	return binary.LittleEndian.Uint64(buff)
+// EffectiveTTL is the requested TTL after TTLPolicy has been applied
+func (req *SigningReq) EffectiveTTL() (time.Duration, error) {
+	if req.TTLPolicy == nil {
+		return req.TTL, nil
+	}
+	return req.TTLPolicy.Apply(req.CertType, req.Principals, req.TTL)
+}
+
+// ValidityWindow is computed at signing time: it opens at StartsAt (or now) minus
+// the clock-skew Backdate and closes the effective TTL after the requested start.
+// A StartsAt no further in the past than Backdate is treated as now.
+func (req *SigningReq) ValidityWindow() (validAfter uint64, validBefore uint64, err error) {
+	now := req.now()
+	if req.Backdate < 0 {
+		return 0, 0, fmt.Errorf("backdate must not be negative, got %s", req.Backdate)
+	}
+	ttl, err := req.EffectiveTTL()
+	if err != nil {
+		return 0, 0, err
+	}
+	start := now
+	if !req.StartsAt.IsZero() {
+		if req.StartsAt.Before(now.Add(-req.Backdate)) {
+			return 0, 0, &PolicyError{fmt.Errorf("requested start time %s is more than %s in the past",
+				req.StartsAt.Format(time.RFC3339), req.Backdate)}
+		}
+		if req.MaxStartDelay > 0 && req.StartsAt.After(now.Add(req.MaxStartDelay)) {
+			return 0, 0, &PolicyError{fmt.Errorf("requested start time %s is more than %s ahead",
+				req.StartsAt.Format(time.RFC3339), req.MaxStartDelay)}
+		}
+		if req.StartsAt.After(now) {
+			start = req.StartsAt
+		}
+	}
+	return uint64(start.Add(-req.Backdate).Unix()), uint64(start.Add(ttl).Unix()), nil
 }
 
 func Sign(req *SigningReq, caKey ssh.Signer) (*ssh.Certificate, error) {
-	certExpiresAt := uint64(time.Now().Add(req.TTL).Unix())
 	pubKey, err := LazyParseAuthorizedKey(req.PublicKey)
+	if err != nil {
+		return nil, &PolicyError{err}
+	}
+	if req.KeyPolicy != nil {
+		if err := req.KeyPolicy.Check(pubKey, req.CertType); err != nil {
+			return nil, &PolicyError{err}
+		}
+	}
+	if req.CertType == ssh.HostCert && req.HostPrincipalPolicy != nil {
+		if err := req.HostPrincipalPolicy.Check(req.Principals); err != nil {
+			return nil, err
+		}
+	}
+	validAfter, validBefore, err := req.ValidityWindow()
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := CertPermissions(req.CertType, req.CriticalOptions, req.Extensions)
+	if err != nil {
+		return nil, &PolicyError{err}
+	}
+	if err := ValidateKeyIDTemplate(req.KeyIDTemplate); err != nil {
+		return nil, err
+	}
+	serials := req.Serials
+	if serials == nil {
+		serials = randomSerialAllocator{}
+	}
+	serial, err := serials.AllocateSerial(req.LookupKey)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := RenderKeyID(req.KeyIDTemplate, KeyIDMetadata{
+		Identity:  req.Identity,
+		Serial:    serial,
+		CallerARN: req.CallerARN,
+		Profile:   req.Profile,
+		IssuedAt:  req.now(),
+		RequestID: req.RequestID,
+	})
 	if err != nil {
 		return nil, err
 	}
 	cert := &ssh.Certificate{
-		Serial:          certSerial(),
+		Serial:          serial,
 		Key:             pubKey,
-		KeyId:           req.Identity,
+		KeyId:           keyID,
 		ValidPrincipals: req.Principals,
-		ValidAfter:      oneMinAgo,
-		ValidBefore:     certExpiresAt,
+		ValidAfter:      validAfter,
+		ValidBefore:     validBefore,
 		CertType:        req.CertType,
-		Permissions: ssh.Permissions{
-			CriticalOptions: nil,
-			Extensions:      nil,
-		},
+		Permissions:     permissions,
 	}
 
 	err = cert.SignCert(rand.Reader, caKey)
@@ -2,12 +2,15 @@ package crypto_test
 
 import (
 	"code.agarg.me/schism/lambda-function/internal/crypto"
+	"errors"
 	"golang.org/x/crypto/ssh"
 	"strings"
 	"testing"
+	"time"
 )
 
-var testSigner, _ = crypto.CreateCA().Signer()
+var testCA, _ = crypto.CreateCA(crypto.CAKeyAlgoED25519)
+var testSigner, _ = testCA.Signer()
 
 func TestMarshalSignedCert(t *testing.T) {
 	var testReq = &crypto.SigningReq{
@@ -18,6 +21,11 @@ func TestMarshalSignedCert(t *testing.T) {
 		TTL:        300,
 	}
 	var testSignedCert, _ = crypto.Sign(testReq, testSigner)
+	var templatedReq = *testReq
+	templatedReq.KeyIDTemplate = "{identity} serial={serial} req={request_id}"
+	templatedReq.RequestID = "abc-123"
+	templatedReq.Serials = crypto.NewMemorySerialAllocator(42)
+	var templatedSignedCert, _ = crypto.Sign(&templatedReq, testSigner)
 
 	type args struct {
 		signedCert *ssh.Certificate
@@ -32,6 +40,11 @@ func TestMarshalSignedCert(t *testing.T) {
 			args:         args{signedCert: testSignedCert},
 			wantContains: testReq.Identity,
 		},
+		{
+			name:         "marshaled cert comment follows the key id template",
+			args:         args{signedCert: templatedSignedCert},
+			wantContains: " test.example.com serial=42 req=abc-123\n",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -51,6 +64,30 @@ func TestSign(t *testing.T) {
 		TTL:        300,
 	}
 	var brokenTestReq = &crypto.SigningReq{}
+	var hostOptionsTestReq = &crypto.SigningReq{
+		PublicKey:       crypto.HelperLoadBytes(t, "ed25519-key.pub"),
+		CertType:        ssh.HostCert,
+		Identity:        "test.example.com",
+		Principals:      []string{"test.example.com"},
+		TTL:             300,
+		CriticalOptions: map[string]string{crypto.OptionForceCommand: "/bin/true"},
+	}
+	var foreignHostTestReq = &crypto.SigningReq{
+		PublicKey:           crypto.HelperLoadBytes(t, "ed25519-key.pub"),
+		CertType:            ssh.HostCert,
+		Identity:            "github.com",
+		Principals:          []string{"github.com"},
+		TTL:                 300,
+		HostPrincipalPolicy: &crypto.HostPrincipalPolicy{AllowedDomains: []string{"example.com"}},
+	}
+	var badTemplateTestReq = &crypto.SigningReq{
+		PublicKey:     crypto.HelperLoadBytes(t, "ed25519-key.pub"),
+		CertType:      ssh.HostCert,
+		Identity:      "test.example.com",
+		Principals:    []string{"test.example.com"},
+		TTL:           300,
+		KeyIDTemplate: "{{.Unknown",
+	}
 	type args struct {
 		req   *crypto.SigningReq
 		caKey ssh.Signer
@@ -60,6 +97,7 @@ func TestSign(t *testing.T) {
 		args          args
 		wantSignature bool
 		wantErr       bool
+		wantPolicyErr bool
 	}{
 		{
 			name: "produces a valid signed certificate",
@@ -78,6 +116,36 @@ func TestSign(t *testing.T) {
 			},
 			wantSignature: false,
 			wantErr:       true,
+			wantPolicyErr: true,
+		},
+		{
+			name: "raises an error for a host cert with critical options",
+			args: args{
+				req:   hostOptionsTestReq,
+				caKey: testSigner,
+			},
+			wantSignature: false,
+			wantErr:       true,
+			wantPolicyErr: true,
+		},
+		{
+			name: "raises an error for a host principal outside the allowed names",
+			args: args{
+				req:   foreignHostTestReq,
+				caKey: testSigner,
+			},
+			wantSignature: false,
+			wantErr:       true,
+			wantPolicyErr: true,
+		},
+		{
+			name: "a broken key id template is not the caller's fault",
+			args: args{
+				req:   badTemplateTestReq,
+				caKey: testSigner,
+			},
+			wantSignature: false,
+			wantErr:       true,
 		},
 	}
 	for _, tt := range tests {
@@ -87,6 +155,10 @@ func TestSign(t *testing.T) {
 				t.Errorf("Sign() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			} else if err != nil {
+				var policyErr *crypto.PolicyError
+				if errors.As(err, &policyErr) != tt.wantPolicyErr {
+					t.Errorf("Sign() error = %v, want a policy rejection %v", err, tt.wantPolicyErr)
+				}
 				return
 			}
 			if got == nil {
@@ -100,3 +172,107 @@ func TestSign(t *testing.T) {
 		})
 	}
 }
+
+func TestSigningReq_ValidityWindow(t *testing.T) {
+	fixedNow := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return fixedNow }
+	tests := []struct {
+		name            string
+		req             *crypto.SigningReq
+		wantValidAfter  time.Time
+		wantValidBefore time.Time
+		wantErr         bool
+	}{
+		{
+			name:            "window opens at signing time minus the backdate",
+			req:             &crypto.SigningReq{TTL: time.Hour, Backdate: time.Minute, Clock: clock},
+			wantValidAfter:  fixedNow.Add(-time.Minute),
+			wantValidBefore: fixedNow.Add(time.Hour),
+		},
+		{
+			name:            "no backdate",
+			req:             &crypto.SigningReq{TTL: time.Hour, Clock: clock},
+			wantValidAfter:  fixedNow,
+			wantValidBefore: fixedNow.Add(time.Hour),
+		},
+		{
+			name: "scheduled access starts in the future",
+			req: &crypto.SigningReq{
+				TTL: time.Hour, Backdate: time.Minute, Clock: clock,
+				StartsAt: fixedNow.Add(24 * time.Hour),
+			},
+			wantValidAfter:  fixedNow.Add(24*time.Hour - time.Minute),
+			wantValidBefore: fixedNow.Add(25 * time.Hour),
+		},
+		{
+			name: "start time within the backdate is now",
+			req: &crypto.SigningReq{
+				TTL: time.Hour, Backdate: time.Minute, Clock: clock,
+				StartsAt: fixedNow.Add(-30 * time.Second),
+			},
+			wantValidAfter:  fixedNow.Add(-time.Minute),
+			wantValidBefore: fixedNow.Add(time.Hour),
+		},
+		{
+			name:    "start time before the backdate is rejected",
+			req:     &crypto.SigningReq{TTL: time.Hour, Backdate: time.Minute, Clock: clock, StartsAt: fixedNow.Add(-time.Hour)},
+			wantErr: true,
+		},
+		{
+			name: "start time up to the max delay",
+			req: &crypto.SigningReq{
+				TTL: time.Hour, Clock: clock, MaxStartDelay: 24 * time.Hour,
+				StartsAt: fixedNow.Add(24 * time.Hour),
+			},
+			wantValidAfter:  fixedNow.Add(24 * time.Hour),
+			wantValidBefore: fixedNow.Add(25 * time.Hour),
+		},
+		{
+			name: "start time past the max delay is rejected",
+			req: &crypto.SigningReq{
+				TTL: time.Hour, Clock: clock, MaxStartDelay: 24 * time.Hour,
+				StartsAt: fixedNow.Add(24*time.Hour + time.Second),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "negative backdate is rejected",
+			req:     &crypto.SigningReq{TTL: time.Hour, Clock: clock, Backdate: -time.Minute},
+			wantErr: true,
+		},
+		{
+			name: "ttl policy clamps the window",
+			req: &crypto.SigningReq{
+				TTL: 30 * 24 * time.Hour, Clock: clock, CertType: ssh.UserCert,
+				TTLPolicy: &crypto.TTLPolicy{User: crypto.TTLLimits{Max: 12 * time.Hour}},
+			},
+			wantValidAfter:  fixedNow,
+			wantValidBefore: fixedNow.Add(12 * time.Hour),
+		},
+		{
+			name: "ttl policy rejects the window",
+			req: &crypto.SigningReq{
+				TTL: 30 * 24 * time.Hour, Clock: clock, CertType: ssh.UserCert,
+				TTLPolicy: &crypto.TTLPolicy{User: crypto.TTLLimits{Max: 12 * time.Hour}, Mode: crypto.TTLModeReject},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAfter, gotBefore, err := tt.req.ValidityWindow()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidityWindow() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			} else if err != nil {
+				return
+			}
+			if gotAfter != uint64(tt.wantValidAfter.Unix()) {
+				t.Errorf("ValidityWindow() validAfter = %v, want %v", time.Unix(int64(gotAfter), 0).UTC(), tt.wantValidAfter)
+			}
+			if gotBefore != uint64(tt.wantValidBefore.Unix()) {
+				t.Errorf("ValidityWindow() validBefore = %v, want %v", time.Unix(int64(gotBefore), 0).UTC(), tt.wantValidBefore)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshSigMagic   = "SSHSIG"
+	sshSigVersion = 1
+	sshSigPEMType = "SSH SIGNATURE"
+
+	ProofOfPossessionNamespace = "schism-pop"
+)
+
+var sshSigHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// SSHSignature is the PROTOCOL.sshsig blob produced by `ssh-keygen -Y sign`
+type SSHSignature struct {
+	PublicKey     ssh.PublicKey
+	Namespace     string
+	HashAlgorithm string
+	Signature     *ssh.Signature
+}
+
+type sshSigBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSigSignedData struct {
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func ParseSSHSignature(armored []byte) (*SSHSignature, error) {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != sshSigPEMType {
+		return nil, fmt.Errorf("not an armored ssh signature")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(sshSigMagic)) {
+		return nil, fmt.Errorf("ssh signature is missing the %s preamble", sshSigMagic)
+	}
+	blob := &sshSigBlob{}
+	if err := ssh.Unmarshal(block.Bytes[len(sshSigMagic):], blob); err != nil {
+		return nil, fmt.Errorf("malformed ssh signature: %w", err)
+	}
+	if blob.Version != sshSigVersion {
+		return nil, fmt.Errorf("unsupported ssh signature version %d", blob.Version)
+	}
+	pubKey, err := ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	signature := &ssh.Signature{}
+	if err := ssh.Unmarshal(blob.Signature, signature); err != nil {
+		return nil, fmt.Errorf("malformed ssh signature: %w", err)
+	}
+	return &SSHSignature{
+		PublicKey:     pubKey,
+		Namespace:     blob.Namespace,
+		HashAlgorithm: blob.HashAlgorithm,
+		Signature:     signature,
+	}, nil
+}
+
+// VerifySSHSignature checks that armored is a signature over message, in
+// namespace, made by the private half of pubKey
+func VerifySSHSignature(pubKey ssh.PublicKey, namespace string, message []byte, armored []byte) error {
+	sig, err := ParseSSHSignature(armored)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sig.PublicKey.Marshal(), pubKey.Marshal()) {
+		return fmt.Errorf("ssh signature was made by %s, not %s",
+			ssh.FingerprintSHA256(sig.PublicKey), ssh.FingerprintSHA256(pubKey))
+	}
+	if sig.Namespace != namespace {
+		return fmt.Errorf("ssh signature namespace is '%s', expected '%s'", sig.Namespace, namespace)
+	}
+	newHash, ok := sshSigHashes[sig.HashAlgorithm]
+	if !ok {
+		return fmt.Errorf("unsupported ssh signature hash %s", sig.HashAlgorithm)
+	}
+	if pubKey.Type() == ssh.KeyAlgoRSA && sig.Signature.Format == ssh.SigAlgoRSA {
+		return fmt.Errorf("ssh-rsa (SHA-1) signatures are not accepted")
+	}
+	messageHash := newHash()
+	messageHash.Write(message)
+	signedData := append([]byte(sshSigMagic), ssh.Marshal(sshSigSignedData{
+		Namespace:     namespace,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          messageHash.Sum(nil),
+	})...)
+	return pubKey.Verify(signedData, sig.Signature)
+}
@@ -0,0 +1,70 @@
+package crypto_test
+
+import (
+	"bytes"
+	"testing"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestVerifySSHSignature(t *testing.T) {
+	message := []byte("schism-test-nonce")
+	ed25519Sig := crypto.HelperLoadBytes(t, "pop-ed25519-nonce.sig")
+	type args struct {
+		publicKey string
+		namespace string
+		message   []byte
+		armored   []byte
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "ed25519 signature from ssh-keygen",
+			args: args{"pop-ed25519-key.pub", crypto.ProofOfPossessionNamespace, message, ed25519Sig},
+		},
+		{
+			name: "rsa signature from ssh-keygen",
+			args: args{"pop-rsa-key.pub", crypto.ProofOfPossessionNamespace, message, crypto.HelperLoadBytes(t, "pop-rsa-nonce.sig")},
+		},
+		{
+			name:    "different message",
+			args:    args{"pop-ed25519-key.pub", crypto.ProofOfPossessionNamespace, []byte("another-nonce"), ed25519Sig},
+			wantErr: true,
+		},
+		{
+			name:    "different key",
+			args:    args{"ed25519-key.pub", crypto.ProofOfPossessionNamespace, message, ed25519Sig},
+			wantErr: true,
+		},
+		{
+			name:    "different namespace",
+			args:    args{"pop-ed25519-key.pub", crypto.ProofOfPossessionNamespace, message, crypto.HelperLoadBytes(t, "pop-ed25519-nonce-file-namespace.sig")},
+			wantErr: true,
+		},
+		{
+			name:    "not armored",
+			args:    args{"pop-ed25519-key.pub", crypto.ProofOfPossessionNamespace, message, []byte("not a signature")},
+			wantErr: true,
+		},
+		{
+			name:    "wrong armor",
+			args:    args{"pop-ed25519-key.pub", crypto.ProofOfPossessionNamespace, message, bytes.Replace(ed25519Sig, []byte("SSH SIGNATURE"), []byte("SSH SIGNATURX"), 2)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pubKey, err := crypto.LazyParseAuthorizedKey(crypto.HelperLoadBytes(t, tt.args.publicKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = crypto.VerifySSHSignature(pubKey, tt.args.namespace, tt.args.message, tt.args.armored)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifySSHSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
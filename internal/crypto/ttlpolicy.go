@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	TTLModeClamp  = "clamp"
+	TTLModeReject = "reject"
+)
+
+type TTLLimits struct {
+	Default time.Duration
+	Max     time.Duration
+}
+
+// TTLPolicy fills in a default TTL when none was asked for and caps it at the
+// lowest ceiling of the certificate type and any requested principal. A zero
+// Max means no ceiling.
+type TTLPolicy struct {
+	Host         TTLLimits
+	User         TTLLimits
+	PrincipalMax map[string]time.Duration
+	Mode         string
+}
+
+func (p *TTLPolicy) limits(certType uint32) TTLLimits {
+	if certType == ssh.HostCert {
+		return p.Host
+	}
+	return p.User
+}
+
+func (p *TTLPolicy) Ceiling(certType uint32, principals []string) time.Duration {
+	ceiling := p.limits(certType).Max
+	for _, principal := range principals {
+		if max, ok := p.PrincipalMax[principal]; ok && max > 0 && (ceiling == 0 || max < ceiling) {
+			ceiling = max
+		}
+	}
+	return ceiling
+}
+
+func (p *TTLPolicy) Apply(certType uint32, principals []string, requested time.Duration) (time.Duration, error) {
+	if requested < 0 {
+		return 0, &PolicyError{fmt.Errorf("ttl must not be negative, got %s", requested)}
+	}
+	ttl := requested
+	if ttl == 0 {
+		ttl = p.limits(certType).Default
+	}
+	ceiling := p.Ceiling(certType, principals)
+	if ceiling == 0 || ttl <= ceiling {
+		return ttl, nil
+	}
+	switch p.Mode {
+	case "", TTLModeClamp:
+		return ceiling, nil
+	case TTLModeReject:
+		return 0, &PolicyError{fmt.Errorf("requested ttl %s exceeds the maximum of %s", ttl, ceiling)}
+	default:
+		return 0, fmt.Errorf("unknown ttl mode: %s", p.Mode)
+	}
+}
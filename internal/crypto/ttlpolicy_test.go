@@ -0,0 +1,112 @@
+package crypto_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+func TestTTLPolicy_Apply(t *testing.T) {
+	policy := &crypto.TTLPolicy{
+		Host:         crypto.TTLLimits{Default: 30 * 24 * time.Hour, Max: 365 * 24 * time.Hour},
+		User:         crypto.TTLLimits{Default: 8 * time.Hour, Max: 24 * time.Hour},
+		PrincipalMax: map[string]time.Duration{"root": time.Hour, "admin": 4 * time.Hour},
+	}
+	rejecting := *policy
+	rejecting.Mode = crypto.TTLModeReject
+	unbounded := &crypto.TTLPolicy{}
+	type args struct {
+		certType   uint32
+		principals []string
+		requested  time.Duration
+	}
+	tests := []struct {
+		name    string
+		policy  *crypto.TTLPolicy
+		args    args
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name:   "zero ttl uses the user default",
+			policy: policy,
+			args:   args{ssh.UserCert, []string{"alice"}, 0},
+			want:   8 * time.Hour,
+		},
+		{
+			name:   "zero ttl uses the host default",
+			policy: policy,
+			args:   args{ssh.HostCert, []string{"web.example.com"}, 0},
+			want:   30 * 24 * time.Hour,
+		},
+		{
+			name:   "within limits is untouched",
+			policy: policy,
+			args:   args{ssh.UserCert, []string{"alice"}, 2 * time.Hour},
+			want:   2 * time.Hour,
+		},
+		{
+			name:   "clamped to the type maximum",
+			policy: policy,
+			args:   args{ssh.UserCert, []string{"alice"}, 10 * 365 * 24 * time.Hour},
+			want:   24 * time.Hour,
+		},
+		{
+			name:   "clamped to the tightest principal maximum",
+			policy: policy,
+			args:   args{ssh.UserCert, []string{"alice", "admin", "root"}, 8 * time.Hour},
+			want:   time.Hour,
+		},
+		{
+			name:   "principal default can be clamped too",
+			policy: policy,
+			args:   args{ssh.UserCert, []string{"admin"}, 0},
+			want:   4 * time.Hour,
+		},
+		{
+			name:    "reject mode refuses long requests",
+			policy:  &rejecting,
+			args:    args{ssh.UserCert, []string{"root"}, 2 * time.Hour},
+			wantErr: true,
+		},
+		{
+			name:   "reject mode allows requests within limits",
+			policy: &rejecting,
+			args:   args{ssh.UserCert, []string{"root"}, time.Hour},
+			want:   time.Hour,
+		},
+		{
+			name:   "no ceiling configured",
+			policy: unbounded,
+			args:   args{ssh.UserCert, []string{"root"}, 10 * 365 * 24 * time.Hour},
+			want:   10 * 365 * 24 * time.Hour,
+		},
+		{
+			name:    "negative ttl",
+			policy:  policy,
+			args:    args{ssh.UserCert, []string{"alice"}, -time.Hour},
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			policy:  &crypto.TTLPolicy{User: crypto.TTLLimits{Max: time.Hour}, Mode: "truncate"},
+			args:    args{ssh.UserCert, []string{"alice"}, 2 * time.Hour},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Apply(tt.args.certType, tt.args.principals, tt.args.requested)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Apply() got = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalDocument accepts the same policy document as JSON or YAML
+func UnmarshalDocument(doc []byte, out interface{}) error {
+	trimmed := bytes.TrimSpace(doc)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return json.Unmarshal(trimmed, out)
+	}
+	return yaml.Unmarshal(trimmed, out)
+}
+
+// Cached keeps a parsed policy document around between warm invocations and
+// reloads it once TTL has passed. If a reload fails the last good copy is
+// returned along with the error.
+type Cached[T any] struct {
+	Load  func() (T, error)
+	TTL   time.Duration
+	Clock func() time.Time
+
+	mu       sync.Mutex
+	value    T
+	loaded   bool
+	loadedAt time.Time
+}
+
+func (c *Cached[T]) Get() (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.Clock != nil {
+		now = c.Clock()
+	}
+	if c.loaded && now.Sub(c.loadedAt) < c.TTL {
+		return c.value, nil
+	}
+	value, err := c.Load()
+	if err != nil {
+		return c.value, err
+	}
+	c.value, c.loaded, c.loadedAt = value, true, now
+	return value, nil
+}
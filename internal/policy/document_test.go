@@ -0,0 +1,81 @@
+package policy_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"code.agarg.me/schism/lambda-function/internal/policy"
+)
+
+func TestCached_Get(t *testing.T) {
+	now := time.Unix(1652000000, 0)
+	loads := 0
+	var loadErr error
+	cached := &policy.Cached[int]{
+		Load: func() (int, error) {
+			if loadErr != nil {
+				return 0, loadErr
+			}
+			loads++
+			return loads, nil
+		},
+		TTL:   time.Minute,
+		Clock: func() time.Time { return now },
+	}
+	tests := []struct {
+		name    string
+		advance time.Duration
+		loadErr error
+		want    int
+		wantErr bool
+	}{
+		{name: "first call loads", want: 1},
+		{name: "within ttl is cached", advance: 30 * time.Second, want: 1},
+		{name: "after ttl reloads", advance: time.Minute, want: 2},
+		{name: "failed reload keeps last value", advance: time.Minute, loadErr: errors.New("boom"), want: 2, wantErr: true},
+		{name: "recovers on next reload", advance: time.Second, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			loadErr = tt.loadErr
+			got, err := cached.Get()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Get() got = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalDocument(t *testing.T) {
+	type doc struct {
+		Name string `json:"name" yaml:"name"`
+	}
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{name: "json", raw: `{"name": "schism"}`, want: "schism"},
+		{name: "yaml", raw: "name: schism\n", want: "schism"},
+		{name: "bad json", raw: `{"name": `, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &doc{}
+			err := policy.UnmarshalDocument([]byte(tt.raw), got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UnmarshalDocument() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got.Name != tt.want {
+				t.Errorf("UnmarshalDocument() got = %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
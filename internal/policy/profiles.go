@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	"code.agarg.me/schism/lambda-function/internal/crypto"
+)
+
+// Duration reads Go duration strings such as "8h" from policy documents
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(raw []byte) error {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return fmt.Errorf("durations must be strings like \"8h\": %w", err)
+	}
+	return d.parse(value)
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	return d.parse(value)
+}
+
+func (d *Duration) parse(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+type Profile struct {
+	Name            string                   `json:"name" yaml:"name"`
+	CertificateType protocol.CertificateType `json:"certificate_type" yaml:"certificate_type"`
+	Principals      []string                 `json:"principals" yaml:"principals"`
+	TTL             Duration                 `json:"ttl" yaml:"ttl"`
+	CriticalOptions map[string]string        `json:"critical_options,omitempty" yaml:"critical_options,omitempty"`
+	Extensions      map[string]string        `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+}
+
+type profileDocument struct {
+	Profiles []*Profile `json:"profiles" yaml:"profiles"`
+}
+
+// ParseProfiles validates every profile up front so a bad document fails the
+// cold start instead of the first request that happens to use it
+func ParseProfiles(doc []byte) (map[string]*Profile, error) {
+	profileDoc := &profileDocument{}
+	if err := UnmarshalDocument(doc, profileDoc); err != nil {
+		return nil, err
+	}
+	profiles := map[string]*Profile{}
+	for i, profile := range profileDoc.Profiles {
+		if profile == nil || profile.Name == "" {
+			return nil, fmt.Errorf("signing profile %d: name is required", i)
+		}
+		if _, exists := profiles[profile.Name]; exists {
+			return nil, fmt.Errorf("signing profile %s: defined more than once", profile.Name)
+		}
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("signing profile %s: %w", profile.Name, err)
+		}
+		profiles[profile.Name] = profile
+	}
+	return profiles, nil
+}
+
+func (p *Profile) validate() error {
+	if p.CertificateType != protocol.HostCertificate && p.CertificateType != protocol.UserCertificate {
+		return fmt.Errorf("unknown certificate_type: %s", p.CertificateType)
+	}
+	if len(p.Principals) == 0 {
+		return fmt.Errorf("at least one principal is required")
+	}
+	if p.TTL <= 0 {
+		return fmt.Errorf("ttl must be positive")
+	}
+	_, err := crypto.CertPermissions(p.CertType(), p.CriticalOptions, p.Extensions)
+	return err
+}
+
+func (p *Profile) CertType() uint32 {
+	if p.CertificateType == protocol.HostCertificate {
+		return ssh.HostCert
+	}
+	return ssh.UserCert
+}
+
+// ResolvePrincipals narrows the profile's principals to those requested, or
+// returns all of them when the caller didn't ask for any
+func (p *Profile) ResolvePrincipals(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return p.Principals, nil
+	}
+	allowed := map[string]bool{}
+	for _, principal := range p.Principals {
+		allowed[principal] = true
+	}
+	for _, principal := range requested {
+		if !allowed[principal] {
+			return nil, fmt.Errorf("principal %s is not part of the %s profile", principal, p.Name)
+		}
+	}
+	return requested, nil
+}
+
+// ResolveTTL uses the profile TTL unless the caller asked for something shorter
+func (p *Profile) ResolveTTL(requested time.Duration) time.Duration {
+	if requested <= 0 || requested > time.Duration(p.TTL) {
+		return time.Duration(p.TTL)
+	}
+	return requested
+}
@@ -0,0 +1,137 @@
+package policy_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	"code.agarg.me/schism/lambda-function/internal/policy"
+)
+
+const testProfilesYAML = `
+profiles:
+  - name: developer
+    certificate_type: user
+    principals: [dev, deploy]
+    ttl: 8h
+  - name: deploy-bot
+    certificate_type: user
+    principals: [deploy]
+    ttl: 15m
+    critical_options:
+      force-command: /usr/local/bin/deploy
+    extensions: {}
+  - name: web-hosts
+    certificate_type: host
+    principals: [web.example.com]
+    ttl: 720h
+`
+
+func TestParseProfiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		doc       string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "yaml", doc: testProfilesYAML, wantNames: []string{"deploy-bot", "developer", "web-hosts"}},
+		{
+			name:      "json",
+			doc:       `{"profiles": [{"name": "oncall-admin", "certificate_type": "user", "principals": ["root"], "ttl": "1h"}]}`,
+			wantNames: []string{"oncall-admin"},
+		},
+		{
+			name:    "duplicate names",
+			doc:     `{"profiles": [{"name": "a", "certificate_type": "user", "principals": ["a"], "ttl": "1h"}, {"name": "a", "certificate_type": "user", "principals": ["a"], "ttl": "1h"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing name",
+			doc:     `{"profiles": [{"certificate_type": "user", "principals": ["a"], "ttl": "1h"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown certificate type",
+			doc:     `{"profiles": [{"name": "a", "certificate_type": "both", "principals": ["a"], "ttl": "1h"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "no principals",
+			doc:     `{"profiles": [{"name": "a", "certificate_type": "user", "ttl": "1h"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing ttl",
+			doc:     `{"profiles": [{"name": "a", "certificate_type": "user", "principals": ["a"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "numeric ttl",
+			doc:     `{"profiles": [{"name": "a", "certificate_type": "user", "principals": ["a"], "ttl": 3600}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "options on a host profile",
+			doc:     `{"profiles": [{"name": "a", "certificate_type": "host", "principals": ["a"], "ttl": "1h", "critical_options": {"force-command": "id"}}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.ParseProfiles([]byte(tt.doc))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseProfiles() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			for _, name := range tt.wantNames {
+				if got[name] == nil {
+					t.Errorf("ParseProfiles() missing profile %s", name)
+				}
+			}
+			if len(got) != len(tt.wantNames) {
+				t.Errorf("ParseProfiles() got %d profiles, want %d", len(got), len(tt.wantNames))
+			}
+		})
+	}
+}
+
+func TestProfile_Resolve(t *testing.T) {
+	profiles, err := policy.ParseProfiles([]byte(testProfilesYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	developer := profiles["developer"]
+	if developer.CertificateType != protocol.UserCertificate || time.Duration(developer.TTL) != 8*time.Hour {
+		t.Fatalf("unexpected developer profile: %+v", developer)
+	}
+	tests := []struct {
+		name           string
+		principals     []string
+		ttl            time.Duration
+		wantPrincipals []string
+		wantTTL        time.Duration
+		wantErr        bool
+	}{
+		{name: "defaults to the whole profile", wantPrincipals: []string{"dev", "deploy"}, wantTTL: 8 * time.Hour},
+		{name: "narrower request", principals: []string{"dev"}, ttl: time.Hour, wantPrincipals: []string{"dev"}, wantTTL: time.Hour},
+		{name: "ttl capped at the profile", principals: []string{"dev"}, ttl: 48 * time.Hour, wantPrincipals: []string{"dev"}, wantTTL: 8 * time.Hour},
+		{name: "principal outside the profile", principals: []string{"root"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPrincipals, err := developer.ResolvePrincipals(tt.principals)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResolvePrincipals() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(gotPrincipals, tt.wantPrincipals) {
+				t.Errorf("ResolvePrincipals() got = %v, want %v", gotPrincipals, tt.wantPrincipals)
+			}
+			if gotTTL := developer.ResolveTTL(tt.ttl); err == nil && gotTTL != tt.wantTTL {
+				t.Errorf("ResolveTTL() got = %s, want %s", gotTTL, tt.wantTTL)
+			}
+		})
+	}
+}
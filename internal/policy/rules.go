@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+const (
+	MatchExact = "exact"
+	MatchGlob  = "glob"
+	MatchRegex = "regex"
+
+	AnyPrincipal = "*"
+)
+
+type Rule struct {
+	Identity        string                   `json:"identity" yaml:"identity"`
+	Match           string                   `json:"match,omitempty" yaml:"match,omitempty"`
+	CertificateType protocol.CertificateType `json:"certificate_type,omitempty" yaml:"certificate_type,omitempty"`
+	Principals      []string                 `json:"principals" yaml:"principals"`
+
+	identityRegex *regexp.Regexp
+}
+
+type RuleSet struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+func ParseRuleSet(doc []byte) (*RuleSet, error) {
+	ruleSet := &RuleSet{}
+	if err := UnmarshalDocument(doc, ruleSet); err != nil {
+		return nil, err
+	}
+	for i := range ruleSet.Rules {
+		if err := ruleSet.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("principal rule %d: %w", i, err)
+		}
+	}
+	return ruleSet, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Identity == "" {
+		return fmt.Errorf("identity is required")
+	}
+	switch r.CertificateType {
+	case "", protocol.HostCertificate, protocol.UserCertificate:
+	default:
+		return fmt.Errorf("unknown certificate_type: %s", r.CertificateType)
+	}
+	switch r.Match {
+	case "", MatchExact:
+	case MatchGlob:
+		if _, err := path.Match(r.Identity, ""); err != nil {
+			return fmt.Errorf("bad identity glob '%s': %w", r.Identity, err)
+		}
+	case MatchRegex:
+		identityRegex, err := regexp.Compile("^(?:" + r.Identity + ")$")
+		if err != nil {
+			return fmt.Errorf("bad identity regex '%s': %w", r.Identity, err)
+		}
+		r.identityRegex = identityRegex
+	default:
+		return fmt.Errorf("unknown match type: %s", r.Match)
+	}
+	return nil
+}
+
+func (r *Rule) matches(identity string, certType protocol.CertificateType) bool {
+	if r.CertificateType != "" && r.CertificateType != certType {
+		return false
+	}
+	switch r.Match {
+	case MatchGlob:
+		matched, _ := path.Match(r.Identity, identity)
+		return matched
+	case MatchRegex:
+		return r.identityRegex.MatchString(identity)
+	default:
+		return r.Identity == identity
+	}
+}
+
+// Allowed is the union of principals from every rule matching identity and certType
+func (rs *RuleSet) Allowed(identity string, certType protocol.CertificateType) map[string]bool {
+	allowed := map[string]bool{}
+	for i := range rs.Rules {
+		if !rs.Rules[i].matches(identity, certType) {
+			continue
+		}
+		for _, principal := range rs.Rules[i].Principals {
+			allowed[principal] = true
+		}
+	}
+	return allowed
+}
+
+// Authorize returns the requested principals identity is not allowed to receive.
+// A certificate without principals is valid for every principal, so an empty
+// request is always denied as AnyPrincipal, even for a wildcard rule.
+func (rs *RuleSet) Authorize(identity string, certType protocol.CertificateType, requested []string) []string {
+	if len(requested) == 0 {
+		return []string{AnyPrincipal}
+	}
+	allowed := rs.Allowed(identity, certType)
+	var denied []string
+	for _, principal := range requested {
+		if !allowed[principal] && !allowed[AnyPrincipal] {
+			denied = append(denied, principal)
+		}
+	}
+	return denied
+}
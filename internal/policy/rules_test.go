@@ -0,0 +1,128 @@
+package policy_test
+
+import (
+	"reflect"
+	"testing"
+
+	"code.agarg.me/schism/commonLib/protocol"
+
+	"code.agarg.me/schism/lambda-function/internal/policy"
+)
+
+const testRulesYAML = `
+rules:
+  - identity: alice@example.com
+    principals: [alice, deploy]
+  - identity: "*@ops.example.com"
+    match: glob
+    certificate_type: user
+    principals: [ops]
+  - identity: "arn:aws:iam::123456789012:role/web-[0-9]+"
+    match: regex
+    certificate_type: host
+    principals: ["*"]
+`
+
+const testRulesJSON = `{"rules": [{"identity": "alice@example.com", "principals": ["alice", "deploy"]}]}`
+
+func TestParseRuleSet(t *testing.T) {
+	tests := []struct {
+		name      string
+		doc       string
+		wantRules int
+		wantErr   bool
+	}{
+		{name: "yaml", doc: testRulesYAML, wantRules: 3},
+		{name: "json", doc: testRulesJSON, wantRules: 1},
+		{name: "empty document", doc: "", wantRules: 0},
+		{name: "bad regex", doc: `{"rules": [{"identity": "(", "match": "regex"}]}`, wantErr: true},
+		{name: "bad glob", doc: `{"rules": [{"identity": "[", "match": "glob"}]}`, wantErr: true},
+		{name: "unknown match", doc: `{"rules": [{"identity": "a", "match": "fuzzy"}]}`, wantErr: true},
+		{name: "unknown cert type", doc: `{"rules": [{"identity": "a", "certificate_type": "both"}]}`, wantErr: true},
+		{name: "missing identity", doc: `{"rules": [{"principals": ["a"]}]}`, wantErr: true},
+		{name: "malformed", doc: `{"rules": `, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.ParseRuleSet([]byte(tt.doc))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRuleSet() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && len(got.Rules) != tt.wantRules {
+				t.Errorf("ParseRuleSet() got %d rules, want %d", len(got.Rules), tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestRuleSet_Authorize(t *testing.T) {
+	ruleSet, err := policy.ParseRuleSet([]byte(testRulesYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type args struct {
+		identity  string
+		certType  protocol.CertificateType
+		requested []string
+	}
+	tests := []struct {
+		name string
+		args args
+		want []string
+	}{
+		{
+			name: "exact identity, allowed principals",
+			args: args{"alice@example.com", protocol.UserCertificate, []string{"alice", "deploy"}},
+			want: nil,
+		},
+		{
+			name: "exact identity, extra principal",
+			args: args{"alice@example.com", protocol.UserCertificate, []string{"alice", "root"}},
+			want: []string{"root"},
+		},
+		{
+			name: "glob identity",
+			args: args{"bob@ops.example.com", protocol.UserCertificate, []string{"ops"}},
+			want: nil,
+		},
+		{
+			name: "glob rule restricted to user certs",
+			args: args{"bob@ops.example.com", protocol.HostCertificate, []string{"ops"}},
+			want: []string{"ops"},
+		},
+		{
+			name: "regex identity with wildcard principals",
+			args: args{"arn:aws:iam::123456789012:role/web-42", protocol.HostCertificate, []string{"web-42.example.com"}},
+			want: nil,
+		},
+		{
+			name: "regex is anchored",
+			args: args{"arn:aws:iam::123456789012:role/web-42-admin", protocol.HostCertificate, []string{"web-42.example.com"}},
+			want: []string{"web-42.example.com"},
+		},
+		{
+			name: "no principals",
+			args: args{"alice@example.com", protocol.UserCertificate, []string{}},
+			want: []string{policy.AnyPrincipal},
+		},
+		{
+			name: "no principals with a wildcard rule",
+			args: args{"arn:aws:iam::123456789012:role/web-42", protocol.HostCertificate, nil},
+			want: []string{policy.AnyPrincipal},
+		},
+		{
+			name: "unknown identity",
+			args: args{"mallory@example.com", protocol.UserCertificate, []string{"alice"}},
+			want: []string{"alice"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ruleSet.Authorize(tt.args.identity, tt.args.certType, tt.args.requested)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Authorize() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}